@@ -0,0 +1,492 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	automation_db "github.com/lampctl/lampctl/automation/db"
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
+	"github.com/lampctl/lampctl/sequencer"
+	"github.com/lampctl/lampctl/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxDepth is the number of rules that may trigger each other in a
+	// chain (by changing lamps that trigger another rule) before the chain
+	// is assumed to be a loop and stopped
+	maxDepth = 5
+
+	// triggerBufferSize is the number of triggers that may be queued before
+	// new ones are dropped
+	triggerBufferSize = 100
+
+	notifyTimeout = 10 * time.Second
+)
+
+var ErrInvalidWebhook = errors.New("no rules use the webhook specified")
+
+// trigger is queued when something happens that may fire rules. Depth is
+// the number of rules in the chain that led to it.
+type trigger struct {
+	kind    string
+	event   *registry.Event
+	webhook string
+	rule    int64
+	depth   int
+}
+
+// Automation runs rules in response to events from the registry, the
+// sequencer, webhooks, and the passing of time.
+type Automation struct {
+	mutex       sync.Mutex
+	logger      zerolog.Logger
+	db          *db.Conn
+	registry    *registry.Registry
+	scheduler   *scheduler.Scheduler
+	client      *http.Client
+	notifyHosts map[string]bool
+	rules       map[int64]*Rule
+	lamps       map[registry.Target]bool
+	runs        map[string]int
+	nextRun     int64
+	watchers    util.Watchers[*Event]
+	unsubscribe func()
+	running     sync.WaitGroup
+	ctx         context.Context
+	cancel      context.CancelFunc
+	triggerChan chan *trigger
+	closeChan   chan any
+	closedChan  chan any
+}
+
+func (a *Automation) run() {
+	defer close(a.closedChan)
+	defer a.logger.Info().Msg("automation stopped")
+	a.logger.Info().Msg("automation started")
+	for {
+		select {
+		case t := <-a.triggerChan:
+			a.handle(t)
+		case <-a.closeChan:
+			return
+		}
+	}
+}
+
+// enqueue adds the trigger to the queue without blocking since it may be
+// called from another package's goroutine.
+func (a *Automation) enqueue(t *trigger) {
+	select {
+	case a.triggerChan <- t:
+	default:
+		a.logger.Warn().Str("trigger", t.kind).Msg("trigger queue is full")
+	}
+}
+
+// depth determines how many rules led to the change that caused the event.
+// Changes made by a rule use a session unique to that run.
+func (a *Automation) depth(e *registry.Event) int {
+	if e.Source == nil || e.Source.Type != registry.SourceAutomation {
+		return 0
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if d, ok := a.runs[e.Source.Session]; ok {
+		return d + 1
+	}
+	return 0
+}
+
+// changedLamps records the state of the lamps in the event and returns those
+// that were turned on or off. Lamps that were not seen before are treated as
+// changed.
+func (a *Automation) changedLamps(e *registry.Event) []*registry.Lamp {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if e.Type == registry.EventProvider {
+		for t := range a.lamps {
+			if t.ProviderID == e.ProviderID {
+				delete(a.lamps, t)
+			}
+		}
+	}
+	changed := []*registry.Lamp{}
+	for _, l := range e.Lamps {
+		t := registry.Target{
+			ProviderID: e.ProviderID,
+			GroupID:    l.GroupID,
+			LampID:     l.ID,
+		}
+		if v, ok := a.lamps[t]; !ok || v != l.State {
+			changed = append(changed, l)
+		}
+		a.lamps[t] = l.State
+	}
+	return changed
+}
+
+// registryEventHandler queues a trigger for lamps that were turned on or off
+// by a change. The state of every lamp is tracked, but frames from effects
+// and the sequencer never trigger rules.
+func (a *Automation) registryEventHandler(e *registry.Event) {
+	switch e.Type {
+	case registry.EventProvider:
+		a.changedLamps(e)
+	case registry.EventLamps:
+		changed := a.changedLamps(e)
+		if e.Source == nil || len(changed) == 0 {
+			return
+		}
+		switch e.Source.Type {
+		case registry.SourceEffect, registry.SourceSequencer:
+			return
+		}
+		v := *e
+		v.Lamps = changed
+		a.enqueue(&trigger{
+			kind:  TriggerLamp,
+			event: &v,
+			depth: a.depth(e),
+		})
+	case registry.EventInput:
+		a.enqueue(&trigger{
+			kind:  TriggerInput,
+			event: e,
+		})
+	}
+}
+
+func (a *Automation) sequencerEventHandler(e *sequencer.TransportEvent) {
+	if e.State == sequencer.TransportFinished {
+		a.enqueue(&trigger{kind: TriggerSequence})
+	}
+}
+
+// handle fires every enabled rule that matches the trigger.
+func (a *Automation) handle(t *trigger) {
+	for _, r := range a.enabledRules() {
+		if r.Trigger.Type != t.kind {
+			continue
+		}
+		switch t.kind {
+		case TriggerLamp:
+			if !a.matchLamps(r.Trigger, t.event) {
+				continue
+			}
+		case TriggerInput:
+			if !matchInput(r.Trigger, t.event) {
+				continue
+			}
+		case TriggerWebhook:
+			if r.Trigger.Webhook != t.webhook {
+				continue
+			}
+		case TriggerTime:
+			if r.ID != t.rule {
+				continue
+			}
+		}
+		a.fire(r, t.depth)
+	}
+}
+
+func (a *Automation) enabledRules() []*Rule {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	rules := []*Rule{}
+	for _, r := range a.rules {
+		if r.Enabled {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// scheduleKey returns the key used for the rule's time trigger.
+func scheduleKey(id int64) string {
+	return fmt.Sprintf("%s:%d", registry.SourceAutomation, id)
+}
+
+// schedule has the scheduler queue a trigger each time the rule's time
+// trigger is reached, replacing any previous schedule for the rule.
+func (a *Automation) schedule(r *Rule) {
+	key := scheduleKey(r.ID)
+	if !r.Enabled || r.Trigger.Type != TriggerTime {
+		a.scheduler.Unschedule(key)
+		return
+	}
+	id := r.ID
+	if err := a.scheduler.Schedule(key, r.Trigger.job(), func() {
+		a.enqueue(&trigger{
+			kind: TriggerTime,
+			rule: id,
+		})
+	}); err != nil {
+		a.scheduler.Unschedule(key)
+		a.logger.Error().Int64("rule", r.ID).Msg(err.Error())
+	}
+}
+
+// fire checks the rule's conditions and runs its actions in a separate
+// goroutine. Rules at the end of a chain that is too long are not run.
+func (a *Automation) fire(r *Rule, depth int) {
+	if depth >= maxDepth {
+		a.logger.Warn().Int64("rule", r.ID).Msg("rule not run to prevent a loop")
+		a.notify(&Event{
+			Type:   EventLoop,
+			RuleID: r.ID,
+		})
+		return
+	}
+	now := time.Now()
+	for _, c := range r.Conditions {
+		if !a.check(c, now) {
+			return
+		}
+	}
+	a.mutex.Lock()
+	a.nextRun++
+	session := fmt.Sprintf("%s:%d:%d", registry.SourceAutomation, r.ID, a.nextRun)
+	a.runs[session] = depth
+	a.mutex.Unlock()
+	a.running.Add(1)
+	go func() {
+		defer a.running.Done()
+		defer func() {
+			a.mutex.Lock()
+			delete(a.runs, session)
+			a.mutex.Unlock()
+		}()
+		a.logger.Info().Int64("rule", r.ID).Msg("running rule")
+		ctx := registry.WithSession(
+			a.ctx,
+			registry.SourceAutomation,
+			strconv.FormatInt(r.ID, 10),
+			session,
+		)
+		e := &Event{
+			Type:   EventFired,
+			RuleID: r.ID,
+		}
+		if err := a.perform(ctx, r); err != nil {
+			a.logger.Error().Int64("rule", r.ID).Msg(err.Error())
+			e.Error = err.Error()
+		}
+		a.notify(e)
+	}()
+}
+
+// perform runs each of the rule's actions in order, stopping at the first
+// one that fails.
+func (a *Automation) perform(ctx context.Context, r *Rule) error {
+	for _, v := range r.Actions {
+		switch v.Type {
+		case ActionDelay:
+			select {
+			case <-time.After(time.Duration(v.Delay) * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case ActionNotify:
+			a.notify(&Event{
+				Type:    EventNotify,
+				RuleID:  r.ID,
+				Message: v.Message,
+			})
+			if v.URL != "" {
+				if err := a.post(ctx, v.URL, r, v.Message); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := a.scheduler.PerformAction(ctx, &v.Action); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// New creates and starts a new automation engine.
+func New(cfg *Config) (*Automation, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Automation{
+		logger:      log.With().Str("package", "automation").Logger(),
+		db:          cfg.DB,
+		registry:    cfg.Registry,
+		scheduler:   cfg.Scheduler,
+		client:      newNotifyClient(),
+		notifyHosts: notifyHosts(cfg.NotifyHosts),
+		rules:       make(map[int64]*Rule),
+		lamps:       make(map[registry.Target]bool),
+		runs:        make(map[string]int),
+		ctx:         ctx,
+		cancel:      cancel,
+		triggerChan: make(chan *trigger, triggerBufferSize),
+		closeChan:   make(chan any),
+		closedChan:  make(chan any),
+	}
+	if err := a.db.AutoMigrate(&automation_db.Rule{}); err != nil {
+		cancel()
+		return nil, err
+	}
+	rows := []*automation_db.Rule{}
+	if err := a.db.Find(&rows).Error; err != nil {
+		cancel()
+		return nil, err
+	}
+	for _, v := range rows {
+		r, err := newRule(v)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		a.rules[r.ID] = r
+		a.schedule(r)
+	}
+	a.unsubscribe = a.registry.Subscribe(a.registryEventHandler)
+	cfg.Sequencer.Watch(a.sequencerEventHandler)
+	go a.run()
+	return a, nil
+}
+
+// Rules returns all stored rules.
+func (a *Automation) Rules() ([]*Rule, error) {
+	rows := []*automation_db.Rule{}
+	if err := a.db.Order("name").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	rules := []*Rule{}
+	for _, v := range rows {
+		r, err := newRule(v)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Rule retrieves the rule with the specified ID.
+func (a *Automation) Rule(id int64) (*Rule, error) {
+	v := &automation_db.Rule{}
+	if err := a.db.First(v, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRule
+		}
+		return nil, err
+	}
+	return newRule(v)
+}
+
+// SaveRule stores the rule in the database, creating it if the ID is zero.
+func (a *Automation) SaveRule(r *Rule) error {
+	if err := a.validate(r); err != nil {
+		return err
+	}
+	if r.ID != 0 {
+		if _, err := a.Rule(r.ID); err != nil {
+			return err
+		}
+	}
+	v := &automation_db.Rule{
+		ID:      r.ID,
+		Name:    r.Name,
+		Enabled: r.Enabled,
+	}
+	for _, f := range []struct {
+		v    interface{}
+		data *string
+	}{
+		{r.Trigger, &v.Trigger},
+		{r.Conditions, &v.Conditions},
+		{r.Actions, &v.Actions},
+	} {
+		b, err := json.Marshal(f.v)
+		if err != nil {
+			return err
+		}
+		*f.data = string(b)
+	}
+	if err := a.db.Save(v).Error; err != nil {
+		return err
+	}
+	r.ID = v.ID
+	stored, err := newRule(v)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	a.rules[r.ID] = stored
+	a.mutex.Unlock()
+	a.schedule(stored)
+	a.notify(&Event{
+		Type:   EventSaved,
+		RuleID: r.ID,
+		Rule:   r,
+	})
+	return nil
+}
+
+// DeleteRule removes the rule with the specified ID.
+func (a *Automation) DeleteRule(id int64) error {
+	if _, err := a.Rule(id); err != nil {
+		return err
+	}
+	if err := a.db.Delete(&automation_db.Rule{}, id).Error; err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	delete(a.rules, id)
+	a.mutex.Unlock()
+	a.scheduler.Unschedule(scheduleKey(id))
+	a.notify(&Event{
+		Type:   EventDeleted,
+		RuleID: id,
+	})
+	return nil
+}
+
+// Webhook triggers the rules that use the webhook with the specified name.
+func (a *Automation) Webhook(name string) error {
+	found := false
+	for _, r := range a.enabledRules() {
+		if r.Trigger.Type == TriggerWebhook && r.Trigger.Webhook == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrInvalidWebhook
+	}
+	a.enqueue(&trigger{
+		kind:    TriggerWebhook,
+		webhook: name,
+	})
+	return nil
+}
+
+// Close stops all running rules and shuts down the engine.
+func (a *Automation) Close() {
+	a.unsubscribe()
+	a.mutex.Lock()
+	for id := range a.rules {
+		a.scheduler.Unschedule(scheduleKey(id))
+	}
+	a.mutex.Unlock()
+	close(a.closeChan)
+	<-a.closedChan
+	a.cancel()
+	a.running.Wait()
+}
@@ -0,0 +1,103 @@
+package automation
+
+import (
+	"time"
+
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/util"
+)
+
+// matchLamps determines whether any of the lamps in the event match the
+// trigger.
+func (a *Automation) matchLamps(t *Trigger, e *registry.Event) bool {
+	if t.ProviderID != "" && t.ProviderID != e.ProviderID {
+		return false
+	}
+	var selected map[registry.Target]bool
+	if t.Selector != nil {
+		selected = map[registry.Target]bool{}
+		for _, v := range a.registry.Select(t.Selector, e.ProviderID) {
+			selected[*v] = true
+		}
+	}
+	for _, l := range e.Lamps {
+		if t.GroupID != "" && t.GroupID != l.GroupID ||
+			t.LampID != "" && t.LampID != l.ID ||
+			t.State != nil && *t.State != l.State {
+			continue
+		}
+		if selected != nil && !selected[registry.Target{
+			ProviderID: e.ProviderID,
+			GroupID:    l.GroupID,
+			LampID:     l.ID,
+		}] {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// matchInput determines whether the input in the event matches the trigger.
+func matchInput(t *Trigger, e *registry.Event) bool {
+	return (t.ProviderID == "" || t.ProviderID == e.ProviderID) &&
+		t.Input == e.Input.ID &&
+		(t.Action == "" || t.Action == e.Input.Action)
+}
+
+// lampState returns the current state of the lamp and whether it exists.
+func (a *Automation) lampState(providerID, groupID, lampID string) (bool, bool) {
+	p, err := a.registry.GetProvider(providerID)
+	if err != nil {
+		return false, false
+	}
+	for _, l := range p.Lamps() {
+		if l.GroupID == groupID && l.ID == lampID {
+			return l.State, true
+		}
+	}
+	return false, false
+}
+
+// check determines whether the condition is met at the specified time.
+// Conditions are validated when the rule is saved so errors are treated as
+// the condition not being met.
+func (a *Automation) check(c *Condition, now time.Time) bool {
+	loc, err := util.LoadTimezone(c.Timezone)
+	if err != nil {
+		return false
+	}
+	now = now.In(loc)
+	switch c.Type {
+	case ConditionTime:
+		var (
+			m      = now.Hour()*60 + now.Minute()
+			after  = 0
+			before = 24 * 60
+		)
+		if c.After != "" {
+			if after, err = util.ParseClock(c.After); err != nil {
+				return false
+			}
+		}
+		if c.Before != "" {
+			if before, err = util.ParseClock(c.Before); err != nil {
+				return false
+			}
+		}
+		if after <= before {
+			return m >= after && m < before
+		}
+		return m >= after || m < before
+	case ConditionLamp:
+		state, ok := a.lampState(c.ProviderID, c.GroupID, c.LampID)
+		return ok && state == c.State
+	case ConditionDay:
+		for _, d := range c.Days {
+			if v, err := parseDay(d); err == nil && v == now.Weekday() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package automation
+
+import (
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
+	"github.com/lampctl/lampctl/sequencer"
+)
+
+// Config provides the configuration for the automation engine.
+// NotifyHosts lists the hosts that notify actions may send messages to.
+type Config struct {
+	DB          *db.Conn
+	Registry    *registry.Registry
+	Sequencer   *sequencer.Sequencer
+	Scheduler   *scheduler.Scheduler
+	NotifyHosts []string
+}
@@ -0,0 +1,12 @@
+package db
+
+// Rule stores an automation rule. The trigger, conditions, and actions are
+// encoded as JSON since they use types from other packages.
+type Rule struct {
+	ID         int64  `gorm:"primaryKey"`
+	Name       string `gorm:"not null"`
+	Enabled    bool   `gorm:"not null"`
+	Trigger    string `gorm:"not null"`
+	Conditions string `gorm:"not null"`
+	Actions    string `gorm:"not null"`
+}
@@ -0,0 +1,29 @@
+package automation
+
+const (
+	EventSaved   = "saved"
+	EventDeleted = "deleted"
+	EventFired   = "fired"
+	EventNotify  = "notify"
+	EventLoop    = "loop"
+)
+
+// Event indicates that a rule was saved, deleted, or fired, that a notify
+// action was run, or that a rule was not run because it would have formed a
+// loop. Error is set if one of the actions failed.
+type Event struct {
+	Type    string `json:"type"`
+	RuleID  int64  `json:"rule_id"`
+	Rule    *Rule  `json:"rule,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Watch registers a function that is invoked for every event.
+func (a *Automation) Watch(fn func(e *Event)) {
+	a.watchers.Add(fn)
+}
+
+func (a *Automation) notify(e *Event) {
+	a.watchers.Notify(e)
+}
@@ -0,0 +1,124 @@
+package automation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/lampctl/lampctl/registry"
+)
+
+var errPrivateAddress = errors.New("notify URL resolves to a local or private address")
+
+type notifyJSON struct {
+	RuleID  int64  `json:"rule_id"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// publicIP determines whether the address is reachable on the internet.
+// Notify actions may not be used to reach lampctl itself or other hosts on
+// the local network.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// newNotifyClient creates a client that refuses to connect to addresses that
+// are not public, which also covers host names that resolve to them.
+// Proxies and redirects are not used since they would bypass the checks.
+func newNotifyClient() *http.Client {
+	d := &net.Dialer{
+		Timeout: notifyTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         d.DialContext,
+			TLSHandshakeTimeout: notifyTimeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkURL ensures that the notify URL uses HTTP and that its host is one
+// of the hosts that notifications may be sent to.
+func (a *Automation) checkURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return registry.Rejectf("invalid notify URL %q", v)
+	}
+	host := strings.ToLower(u.Hostname())
+	if !a.notifyHosts[host] {
+		return registry.Rejectf("notifications cannot be sent to %q", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return registry.Rejectf("notifications cannot be sent to %q", host)
+	}
+	return nil
+}
+
+// post sends the message from a notify action to the URL.
+func (a *Automation) post(ctx context.Context, url string, r *Rule, message string) error {
+	if err := a.checkURL(url); err != nil {
+		return err
+	}
+	b, err := json.Marshal(&notifyJSON{
+		RuleID:  r.ID,
+		Rule:    r.Name,
+		Message: message,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notify URL returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyHosts converts the list of hosts to a set.
+func notifyHosts(hosts []string) map[string]bool {
+	m := map[string]bool{}
+	for _, h := range hosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			m[h] = true
+		}
+	}
+	return m
+}
@@ -0,0 +1,259 @@
+package automation
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	automation_db "github.com/lampctl/lampctl/automation/db"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
+	"github.com/lampctl/lampctl/util"
+)
+
+const (
+	TriggerLamp     = "lamp"
+	TriggerTime     = "time"
+	TriggerWebhook  = "webhook"
+	TriggerSequence = "sequence"
+	TriggerInput    = "input"
+
+	ConditionTime = "time"
+	ConditionLamp = "lamp"
+	ConditionDay  = "day"
+
+	ActionDelay  = "delay"
+	ActionNotify = "notify"
+)
+
+var (
+	ErrInvalidRule        = errors.New("invalid rule specified")
+	ErrInvalidTriggerType = errors.New("invalid trigger type specified")
+	ErrInvalidCondition   = errors.New("invalid condition type specified")
+)
+
+var days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Trigger determines when a rule is evaluated:
+//
+//   - lamp: a lamp matching the provider, group, and lamp (empty fields
+//     match any) or the selector changed and, if State is set, is in that
+//     state
+//   - time: the cron expression or solar trigger was reached, as for
+//     scheduled jobs
+//   - webhook: the webhook with the name was called
+//   - sequence: a sequence finished playing
+//   - input: the input was used with the action (or any action if empty)
+type Trigger struct {
+	Type       string             `json:"type"`
+	ProviderID string             `json:"provider_id,omitempty"`
+	GroupID    string             `json:"group_id,omitempty"`
+	LampID     string             `json:"lamp_id,omitempty"`
+	Selector   *registry.Selector `json:"selector,omitempty"`
+	State      *bool              `json:"state,omitempty"`
+	Cron       string             `json:"cron,omitempty"`
+	Solar      string             `json:"solar,omitempty"`
+	Timezone   string             `json:"timezone,omitempty"`
+	Webhook    string             `json:"webhook,omitempty"`
+	Input      string             `json:"input,omitempty"`
+	Action     string             `json:"action,omitempty"`
+}
+
+// job returns a job with the trigger's schedule for calculating runs.
+func (t *Trigger) job() *scheduler.Job {
+	return &scheduler.Job{
+		Cron:     t.Cron,
+		Solar:    t.Solar,
+		Timezone: t.Timezone,
+	}
+}
+
+// Condition must be met for a rule's actions to run:
+//
+//   - time: the current time is between After and Before (in the form
+//     "15:04"), either of which may be omitted; the window may span midnight
+//   - lamp: the lamp is in the specified state
+//   - day: the current day of the week is in Days ("mon", "tue", etc.)
+//
+// Times and days use the timezone, which defaults to the local timezone.
+type Condition struct {
+	Type       string   `json:"type"`
+	After      string   `json:"after,omitempty"`
+	Before     string   `json:"before,omitempty"`
+	Timezone   string   `json:"timezone,omitempty"`
+	ProviderID string   `json:"provider_id,omitempty"`
+	GroupID    string   `json:"group_id,omitempty"`
+	LampID     string   `json:"lamp_id,omitempty"`
+	State      bool     `json:"state"`
+	Days       []string `json:"days,omitempty"`
+}
+
+// Action is run when a rule fires. In addition to the actions supported by
+// the scheduler, delay waits for the specified number of milliseconds before
+// running the next action and notify sends the message to clients and, if
+// provided, to the URL, whose host must be one that notifications may be
+// sent to.
+type Action struct {
+	scheduler.Action
+	Delay   int64  `json:"delay,omitempty"`
+	Message string `json:"message,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Rule runs its actions in order when it is triggered, provided that all
+// of its conditions are met.
+type Rule struct {
+	ID         int64        `json:"id"`
+	Name       string       `json:"name"`
+	Enabled    bool         `json:"enabled"`
+	Trigger    *Trigger     `json:"trigger"`
+	Conditions []*Condition `json:"conditions"`
+	Actions    []*Action    `json:"actions"`
+}
+
+func newRule(v *automation_db.Rule) (*Rule, error) {
+	r := &Rule{
+		ID:      v.ID,
+		Name:    v.Name,
+		Enabled: v.Enabled,
+	}
+	for _, f := range []struct {
+		data string
+		v    interface{}
+	}{
+		{v.Trigger, &r.Trigger},
+		{v.Conditions, &r.Conditions},
+		{v.Actions, &r.Actions},
+	} {
+		if err := json.Unmarshal([]byte(f.data), f.v); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func parseDay(v string) (time.Weekday, error) {
+	v = strings.ToLower(v)
+	for i, d := range days {
+		if len(v) >= 3 && strings.HasPrefix(d, v[:3]) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, registry.Rejectf("invalid day %q", v)
+}
+
+func (a *Automation) validateTrigger(t *Trigger) error {
+	if t == nil {
+		return registry.Rejectf("trigger is required")
+	}
+	switch t.Type {
+	case TriggerLamp, TriggerSequence:
+	case TriggerTime:
+		if _, err := a.scheduler.Preview(t.job(), time.Now(), 1); err != nil {
+			return err
+		}
+	case TriggerWebhook:
+		if t.Webhook == "" {
+			return registry.Rejectf("webhook name is required")
+		}
+	case TriggerInput:
+		if t.Input == "" {
+			return registry.Rejectf("input is required")
+		}
+	default:
+		return ErrInvalidTriggerType
+	}
+	return nil
+}
+
+func validateCondition(c *Condition) error {
+	if _, err := util.LoadTimezone(c.Timezone); err != nil {
+		return err
+	}
+	switch c.Type {
+	case ConditionTime:
+		if c.After == "" && c.Before == "" {
+			return registry.Rejectf("time condition requires after or before")
+		}
+		for _, v := range []string{c.After, c.Before} {
+			if v == "" {
+				continue
+			}
+			if _, err := util.ParseClock(v); err != nil {
+				return err
+			}
+		}
+	case ConditionLamp:
+		if c.ProviderID == "" || c.GroupID == "" || c.LampID == "" {
+			return registry.Rejectf("lamp condition requires provider, group, and lamp")
+		}
+	case ConditionDay:
+		if len(c.Days) == 0 {
+			return registry.Rejectf("day condition requires at least one day")
+		}
+		for _, d := range c.Days {
+			if _, err := parseDay(d); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrInvalidCondition
+	}
+	return nil
+}
+
+func (a *Automation) validateAction(v *Action) error {
+	switch v.Type {
+	case ActionDelay:
+		if v.Delay <= 0 {
+			return registry.Rejectf("delay must be greater than zero")
+		}
+	case ActionNotify:
+		if v.Message == "" {
+			return registry.Rejectf("notify requires a message")
+		}
+		if v.URL != "" {
+			if err := a.checkURL(v.URL); err != nil {
+				return err
+			}
+		}
+	default:
+		return a.scheduler.ValidateAction(&v.Action)
+	}
+	return nil
+}
+
+// validate checks every part of the rule.
+func (a *Automation) validate(r *Rule) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return registry.Rejectf("rule name is required")
+	}
+	if err := a.validateTrigger(r.Trigger); err != nil {
+		return err
+	}
+	if r.Conditions == nil {
+		r.Conditions = []*Condition{}
+	}
+	for _, c := range r.Conditions {
+		if c == nil {
+			return registry.Rejectf("conditions cannot be null")
+		}
+		if err := validateCondition(c); err != nil {
+			return err
+		}
+	}
+	if len(r.Actions) == 0 {
+		return registry.Rejectf("at least one action is required")
+	}
+	for _, v := range r.Actions {
+		if v == nil {
+			return registry.Rejectf("actions cannot be null")
+		}
+		if err := a.validateAction(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
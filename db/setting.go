@@ -15,7 +15,7 @@ type Setting struct {
 
 func (c *Conn) getSetting(key string) (string, error) {
 	s := &Setting{}
-	if err := c.DB.First(s).Error; err != nil {
+	if err := c.DB.First(s, "key = ?", key).Error; err != nil {
 		return "", err
 	}
 	return s.Value, nil
@@ -45,6 +45,18 @@ func (c *Conn) GetIntSetting(key string, def int) (int, error) {
 	return strconv.Atoi(v)
 }
 
+// GetFloatSetting retrieves a setting's floating-point value by key.
+func (c *Conn) GetFloatSetting(key string, def float64) (float64, error) {
+	v, err := c.getSetting(key)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return def, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
 // GetIntSetting retrieves a setting's boolean value by key.
 func (c *Conn) GetBoolSetting(key string, def bool) (bool, error) {
 	var intDef int
@@ -74,6 +86,11 @@ func (c *Conn) SetIntSetting(key string, value int) error {
 	return c.SetStringSetting(key, strconv.Itoa(value))
 }
 
+// SetFloatSetting stores a floating-point value for the specified key.
+func (c *Conn) SetFloatSetting(key string, value float64) error {
+	return c.SetStringSetting(key, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
 // SetBoolSetting stores a boolean value for the specified key.
 func (c *Conn) SetBoolSetting(key string, value bool) error {
 	var intVal int
@@ -0,0 +1,212 @@
+package effects
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+var (
+	white  = colorful.Color{R: 1, G: 1, B: 1}
+	red    = colorful.Color{R: 1, G: 0.1, B: 0}
+	yellow = colorful.Color{R: 1, G: 0.6, B: 0.05}
+)
+
+// builtins maps effect names to functions that create their renderers.
+var builtins = map[string]func(*Params) Renderer{
+	"chase":   newChase,
+	"rainbow": newRainbow,
+	"twinkle": newTwinkle,
+	"breathe": newBreathe,
+	"strobe":  newStrobe,
+	"fire":    newFire,
+	"comet":   newComet,
+}
+
+// cycles returns the number of cycles that have elapsed at the specified time
+// based on the speed parameter.
+func cycles(p *Params, t time.Duration) float64 {
+	return t.Seconds() * p.Speed
+}
+
+// chase lights one lamp at a time, moving along the lamps once per cycle and
+// switching to the next color after each pass.
+type chase struct {
+	params *Params
+}
+
+func newChase(p *Params) Renderer {
+	return &chase{params: p}
+}
+
+func (c *chase) Render(t time.Duration, lamps []*LampState) error {
+	var (
+		n   = float64(len(lamps))
+		pos = cycles(c.params, t) * n
+		idx = int(pos) % len(lamps)
+		col = c.params.color(int(pos / n))
+	)
+	for i, l := range lamps {
+		if i == idx {
+			l.Set(1, col)
+		} else {
+			l.Off()
+		}
+	}
+	return nil
+}
+
+// rainbow spreads the color wheel across the lamps and rotates it once per
+// cycle.
+type rainbow struct {
+	params *Params
+}
+
+func newRainbow(p *Params) Renderer {
+	return &rainbow{params: p}
+}
+
+func (r *rainbow) Render(t time.Duration, lamps []*LampState) error {
+	offset := cycles(r.params, t)
+	for i, l := range lamps {
+		h := math.Mod(float64(i)/float64(len(lamps))+offset, 1) * 360
+		l.Set(1, colorful.Hsv(h, 1, 1))
+	}
+	return nil
+}
+
+// twinkle fades each lamp in and out with a random phase and period.
+type twinkle struct {
+	params  *Params
+	phases  []float64
+	periods []float64
+}
+
+func newTwinkle(p *Params) Renderer {
+	return &twinkle{params: p}
+}
+
+func (tw *twinkle) Render(t time.Duration, lamps []*LampState) error {
+	for len(tw.phases) < len(lamps) {
+		tw.phases = append(tw.phases, rand.Float64())
+		tw.periods = append(tw.periods, 0.5+rand.Float64())
+	}
+	c := cycles(tw.params, t)
+	for i, l := range lamps {
+		v := math.Sin(2 * math.Pi * (c/tw.periods[i] + tw.phases[i]))
+		if v > 0 {
+			l.Set(v, tw.params.color(i))
+		} else {
+			l.Off()
+		}
+	}
+	return nil
+}
+
+// breathe slowly fades all lamps in and out together, switching to the next
+// color after each breath.
+type breathe struct {
+	params *Params
+}
+
+func newBreathe(p *Params) Renderer {
+	return &breathe{params: p}
+}
+
+func (b *breathe) Render(t time.Duration, lamps []*LampState) error {
+	var (
+		c   = cycles(b.params, t)
+		v   = (1 - math.Cos(2*math.Pi*c)) / 2
+		col = b.params.color(int(c))
+	)
+	for _, l := range lamps {
+		l.Set(v, col)
+	}
+	return nil
+}
+
+// strobe flashes all lamps on and off once per cycle.
+type strobe struct {
+	params *Params
+}
+
+func newStrobe(p *Params) Renderer {
+	return &strobe{params: p}
+}
+
+func (s *strobe) Render(t time.Duration, lamps []*LampState) error {
+	var (
+		c   = cycles(s.params, t)
+		on  = math.Mod(c, 1) < 0.5
+		col = s.params.color(int(c))
+	)
+	for _, l := range lamps {
+		if on {
+			l.Set(1, col)
+		} else {
+			l.Off()
+		}
+	}
+	return nil
+}
+
+// fire flickers the lamps randomly between red and yellow. Each lamp drifts
+// towards a new random target, with the speed controlling how quickly.
+type fire struct {
+	params  *Params
+	last    time.Duration
+	heat    []float64
+	targets []float64
+}
+
+func newFire(p *Params) Renderer {
+	return &fire{params: p}
+}
+
+func (f *fire) Render(t time.Duration, lamps []*LampState) error {
+	for len(f.heat) < len(lamps) {
+		f.heat = append(f.heat, rand.Float64())
+		f.targets = append(f.targets, rand.Float64())
+	}
+	step := math.Min(1, (t-f.last).Seconds()*f.params.Speed*4)
+	f.last = t
+	for i, l := range lamps {
+		if math.Abs(f.heat[i]-f.targets[i]) < 0.05 {
+			f.targets[i] = rand.Float64()
+		}
+		f.heat[i] += (f.targets[i] - f.heat[i]) * step
+		l.Set(0.4+0.6*f.heat[i], red.BlendRgb(yellow, f.heat[i]))
+	}
+	return nil
+}
+
+// comet moves a bright head along the lamps once per cycle, followed by a
+// fading tail a quarter of the length of the lamps.
+type comet struct {
+	params *Params
+}
+
+func newComet(p *Params) Renderer {
+	return &comet{params: p}
+}
+
+func (c *comet) Render(t time.Duration, lamps []*LampState) error {
+	var (
+		n    = float64(len(lamps))
+		pos  = cycles(c.params, t) * n
+		head = math.Mod(pos, n)
+		tail = math.Max(1, n/4)
+		col  = c.params.color(int(pos / n))
+	)
+	for i, l := range lamps {
+		d := math.Mod(head-float64(i)+n, n)
+		if d < tail {
+			l.Set(1-d/tail, col)
+		} else {
+			l.Off()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package effects
+
+import (
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+)
+
+// Config provides the configuration for the effects engine.
+type Config struct {
+	DB        *db.Conn
+	Registry  *registry.Registry
+	FrameRate int
+}
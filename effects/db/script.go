@@ -0,0 +1,8 @@
+package db
+
+// Script provides database storage for user-provided effect scripts.
+type Script struct {
+	ID     int64  `gorm:"primaryKey" json:"id"`
+	Name   string `gorm:"not null" json:"name"`
+	Source string `gorm:"not null" json:"source"`
+}
@@ -0,0 +1,442 @@
+package effects
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	effects_db "github.com/lampctl/lampctl/effects/db"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	DirectionForward = "forward"
+	DirectionReverse = "reverse"
+
+	defaultFrameRate = 30
+)
+
+var (
+	ErrInvalidEffect     = errors.New("invalid effect specified")
+	ErrInvalidEffectType = errors.New("invalid effect type specified")
+	ErrInvalidDirection  = errors.New("invalid direction specified")
+	ErrNoTargets         = errors.New("no lamps specified for effect")
+	ErrInvalidScript     = errors.New("invalid script specified")
+	ErrScriptCompile     = errors.New("script failed to compile")
+)
+
+// Params controls the appearance of an effect. Speed is the number of cycles
+// per second (the meaning of a cycle depends on the effect) and Colors is the
+// list of colors that the effect cycles through.
+type Params struct {
+	Speed     float64           `json:"speed"`
+	Colors    []*registry.Color `json:"colors"`
+	Direction string            `json:"direction"`
+
+	// ScriptID specifies the script to run for script effects.
+	ScriptID int64 `json:"script_id,omitempty"`
+}
+
+func (p *Params) color(i int) colorful.Color {
+	if len(p.Colors) == 0 {
+		return white
+	}
+	return p.Colors[i%len(p.Colors)].Color
+}
+
+// LampState is the state of a single lamp in a frame. A brightness of zero
+// indicates that the lamp is off.
+type LampState struct {
+	Brightness float64
+	Color      colorful.Color
+}
+
+// Set turns the lamp on with the specified brightness and color.
+func (l *LampState) Set(brightness float64, color colorful.Color) {
+	l.Brightness = math.Max(0, math.Min(1, brightness))
+	l.Color = color
+}
+
+// Off turns the lamp off.
+func (l *LampState) Off() {
+	l.Brightness = 0
+}
+
+// Renderer computes the state of each lamp for a frame. Lamps are provided in
+// the order in which the effect should run. Returning an error stops the
+// effect.
+type Renderer interface {
+	Render(t time.Duration, lamps []*LampState) error
+}
+
+// Effect represents an effect that is running on a set of lamps.
+type Effect struct {
+	ID      string             `json:"id"`
+	Type    string             `json:"type"`
+	Targets []*registry.Target `json:"targets"`
+	Params  *Params            `json:"params"`
+	Started time.Time          `json:"started"`
+
+	renderer Renderer
+	states   []*LampState
+	applied  []*registry.Change
+}
+
+// render computes the next frame and returns the changes for lamps whose
+// state differs from the previous frame.
+func (e *Effect) render(now time.Time) ([]*registry.Change, error) {
+	for _, s := range e.states {
+		s.Off()
+	}
+	states := e.states
+	if e.Params.Direction == DirectionReverse {
+		states = make([]*LampState, len(e.states))
+		for i, s := range e.states {
+			states[len(states)-1-i] = s
+		}
+	}
+	if err := e.renderer.Render(now.Sub(e.Started), states); err != nil {
+		return nil, err
+	}
+	changes := []*registry.Change{}
+	for i, s := range e.states {
+		var (
+			t = e.Targets[i]
+			c = &registry.Change{
+				ProviderID: t.ProviderID,
+				GroupID:    t.GroupID,
+				LampID:     t.LampID,
+				State:      s.Brightness > 0,
+			}
+		)
+		if c.State {
+			c.Brightness = s.Brightness
+			c.Color = &registry.Color{Color: s.Color}
+		}
+		if a := e.applied[i]; a != nil && sameChange(a, c) {
+			continue
+		}
+		e.applied[i] = c
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func sameChange(a, b *registry.Change) bool {
+	if a.State != b.State || a.Brightness != b.Brightness {
+		return false
+	}
+	if a.Color == nil || b.Color == nil {
+		return a.Color == b.Color
+	}
+	return a.Color.Hex() == b.Color.Hex()
+}
+
+// Engine runs effects on a shared ticker, rendering each frame and applying
+// the changes through the registry.
+type Engine struct {
+	mutex      sync.Mutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	registry   *registry.Registry
+	interval   time.Duration
+	effects    map[string]*Effect
+	nextID     int64
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func (e *Engine) run() {
+	defer close(e.closedChan)
+	defer e.logger.Info().Msg("effects engine stopped")
+	e.logger.Info().Msg("effects engine started")
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		if e.idle() {
+			select {
+			case <-e.wakeChan:
+			case <-e.closeChan:
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+			e.render(time.Now())
+		case <-e.closeChan:
+			return
+		}
+	}
+}
+
+func (e *Engine) idle() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.effects) == 0
+}
+
+func (e *Engine) render(now time.Time) {
+	changes := map[string][]*registry.Change{}
+	func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		for id, v := range e.effects {
+			m, err := v.render(now)
+			if err != nil {
+				e.logger.Error().Str("effect", id).Msg(err.Error())
+				delete(e.effects, id)
+				continue
+			}
+			if len(m) > 0 {
+				changes[id] = m
+			}
+		}
+	}()
+	for id, c := range changes {
+		e.apply(id, c)
+	}
+}
+
+// apply applies changes on behalf of the effect with the specified ID.
+func (e *Engine) apply(id string, changes []*registry.Change) {
+	ctx := registry.WithSource(context.Background(), registry.SourceEffect, id)
+	if err := registry.Err(e.registry.ApplyChanges(ctx, changes)); err != nil {
+		e.logger.Error().Str("effect", id).Msg(err.Error())
+	}
+}
+
+// New creates and starts a new effects engine.
+func New(cfg *Config) (*Engine, error) {
+	frameRate := cfg.FrameRate
+	if frameRate <= 0 {
+		frameRate = defaultFrameRate
+	}
+	e := &Engine{
+		logger:     log.With().Str("package", "effects").Logger(),
+		db:         cfg.DB,
+		registry:   cfg.Registry,
+		interval:   time.Second / time.Duration(frameRate),
+		effects:    make(map[string]*Effect),
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	if err := e.db.AutoMigrate(&effects_db.Script{}); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+// Types returns the names of all available effects.
+func (e *Engine) Types() []string {
+	types := []string{}
+	for k := range builtins {
+		types = append(types, k)
+	}
+	types = append(types, TypeScript)
+	sort.Strings(types)
+	return types
+}
+
+// Effects returns a list of all running effects.
+func (e *Engine) Effects() []*Effect {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	effects := []*Effect{}
+	for _, v := range e.effects {
+		effects = append(effects, v)
+	}
+	sort.Slice(effects, func(i, j int) bool {
+		return effects[i].Started.Before(effects[j].Started)
+	})
+	return effects
+}
+
+// Start begins running an effect on the specified lamps. Any running effects
+// that share a lamp with the new one are stopped.
+func (e *Engine) Start(
+	effectType string,
+	targets []*registry.Target,
+	params *Params,
+) (*Effect, error) {
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	}
+	for _, t := range targets {
+		if _, err := e.registry.GetProvider(t.ProviderID); err != nil {
+			return nil, err
+		}
+	}
+	if params == nil {
+		params = &Params{}
+	}
+	if params.Speed <= 0 {
+		params.Speed = 1
+	}
+	switch params.Direction {
+	case "":
+		params.Direction = DirectionForward
+	case DirectionForward, DirectionReverse:
+	default:
+		return nil, ErrInvalidDirection
+	}
+	renderer, err := e.newRenderer(effectType, targets, params)
+	if err != nil {
+		return nil, err
+	}
+	v := &Effect{
+		Type:     effectType,
+		Targets:  targets,
+		Params:   params,
+		Started:  time.Now(),
+		renderer: renderer,
+		states:   make([]*LampState, len(targets)),
+		applied:  make([]*registry.Change, len(targets)),
+	}
+	for i := range v.states {
+		v.states[i] = &LampState{}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for id, o := range e.effects {
+		if overlaps(o, v) {
+			delete(e.effects, id)
+		}
+	}
+	e.nextID++
+	v.ID = strconv.FormatInt(e.nextID, 10)
+	e.effects[v.ID] = v
+	select {
+	case e.wakeChan <- nil:
+	default:
+	}
+	e.record(v, "started "+effectType)
+	return v, nil
+}
+
+func (e *Engine) newRenderer(
+	effectType string,
+	targets []*registry.Target,
+	params *Params,
+) (Renderer, error) {
+	if effectType == TypeScript {
+		s, err := e.Script(params.ScriptID)
+		if err != nil {
+			return nil, err
+		}
+		ordered := targets
+		if params.Direction == DirectionReverse {
+			ordered = make([]*registry.Target, len(targets))
+			for i, t := range targets {
+				ordered[len(targets)-1-i] = t
+			}
+		}
+		return compileScript(s.Name, s.Source, params, ordered)
+	}
+	fn, ok := builtins[effectType]
+	if !ok {
+		return nil, ErrInvalidEffectType
+	}
+	return fn(params), nil
+}
+
+func overlaps(a, b *Effect) bool {
+	m := map[registry.Target]bool{}
+	for _, t := range a.Targets {
+		m[*t] = true
+	}
+	for _, t := range b.Targets {
+		if m[*t] {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop ends the specified effect and switches its lamps off.
+func (e *Engine) Stop(id string) error {
+	v, err := func() (*Effect, error) {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		v, ok := e.effects[id]
+		if !ok {
+			return nil, ErrInvalidEffect
+		}
+		delete(e.effects, id)
+		return v, nil
+	}()
+	if err != nil {
+		return err
+	}
+	changes := []*registry.Change{}
+	for _, t := range v.Targets {
+		changes = append(changes, &registry.Change{
+			ProviderID: t.ProviderID,
+			GroupID:    t.GroupID,
+			LampID:     t.LampID,
+		})
+	}
+	e.apply(id, changes)
+	e.record(v, "stopped "+v.Type)
+	return nil
+}
+
+// record adds an entry to the history for each of the effect's lamps. The
+// frames themselves are not recorded.
+func (e *Engine) record(v *Effect, detail string) {
+	ctx := registry.WithSource(context.Background(), registry.SourceEffect, v.ID)
+	e.registry.RecordHistory(ctx, v.Targets, detail)
+}
+
+// Close stops all effects and shuts down the engine.
+func (e *Engine) Close() {
+	close(e.closeChan)
+	<-e.closedChan
+}
+
+// Scripts returns all stored effect scripts.
+func (e *Engine) Scripts() ([]*effects_db.Script, error) {
+	scripts := []*effects_db.Script{}
+	if err := e.db.Order("name").Find(&scripts).Error; err != nil {
+		return nil, err
+	}
+	return scripts, nil
+}
+
+// Script retrieves the script with the specified ID.
+func (e *Engine) Script(id int64) (*effects_db.Script, error) {
+	s := &effects_db.Script{}
+	if err := e.db.First(s, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidScript
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveScript checks that the script compiles and stores it in the database,
+// creating it if the ID is zero.
+func (e *Engine) SaveScript(s *effects_db.Script) error {
+	if _, err := compileScript(s.Name, s.Source, &Params{}, nil); err != nil {
+		return fmt.Errorf("%w: %s", ErrScriptCompile, err)
+	}
+	return e.db.Save(s).Error
+}
+
+// DeleteScript removes the script with the specified ID.
+func (e *Engine) DeleteScript(id int64) error {
+	return e.db.Delete(&effects_db.Script{}, id).Error
+}
@@ -0,0 +1,241 @@
+package effects
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lucasb-eyer/go-colorful"
+	"go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// TypeScript is the effect type used to run a user-provided script.
+	TypeScript = "script"
+
+	// maxScriptSteps limits the number of instructions that a script may
+	// execute during a single call, preventing runaway loops.
+	maxScriptSteps = 1000000
+
+	// scriptTimeout limits the amount of time a script may take to compute
+	// a single frame.
+	scriptTimeout = 50 * time.Millisecond
+)
+
+var (
+	errNoFrameFunc   = errors.New("script must define a frame(t, lamps) function")
+	errInvalidReturn = errors.New("frame() must return a list of changes")
+	errScriptLoad    = errors.New("scripts may not load modules")
+)
+
+// script renders frames by calling the frame() function defined in a
+// Starlark script. Scripts have no access to the filesystem or network; the
+// only predeclared names are listed in predeclared() below.
+//
+// The function is called with the number of seconds since the effect started
+// and a list of lamps, each a struct with index, provider_id, group_id, and
+// lamp_id fields. It must return a list of dicts with the same keys as
+// registry.Change, with either index or provider_id / group_id / lamp_id used
+// to identify the lamp. Lamps not included in the list are switched off.
+type script struct {
+	name    string
+	fn      starlark.Callable
+	lamps   *starlark.List
+	targets map[registry.Target]int
+}
+
+func predeclared(p *Params) starlark.StringDict {
+	colors := []starlark.Value{}
+	for _, c := range p.Colors {
+		colors = append(colors, starlark.String(c.String()))
+	}
+	return starlark.StringDict{
+		"math":   math.Module,
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"hsv":    starlark.NewBuiltin("hsv", scriptHSV),
+		"state":  starlark.NewDict(0),
+		"params": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"speed":     starlark.Float(p.Speed),
+			"colors":    starlark.NewList(colors),
+			"direction": starlark.String(p.Direction),
+		}),
+	}
+}
+
+// scriptHSV converts a hue in degrees and saturation and value between 0 and
+// 1 to a hex color string.
+func scriptHSV(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var h, s, v starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "h", &h, "s", &s, "v", &v); err != nil {
+		return nil, err
+	}
+	values := []float64{}
+	for _, x := range []starlark.Value{h, s, v} {
+		f, ok := starlark.AsFloat(x)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected number, got %s", b.Name(), x.Type())
+		}
+		values = append(values, f)
+	}
+	return starlark.String(registry.NewHSVColor(values[0], values[1], values[2]).String()), nil
+}
+
+func newThread(name string) *starlark.Thread {
+	t := &starlark.Thread{
+		Name: name,
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, errScriptLoad
+		},
+	}
+	t.SetMaxExecutionSteps(maxScriptSteps)
+	return t
+}
+
+// compileScript executes the top level of the script and locates the frame
+// function. The targets must be in the order in which they are rendered.
+func compileScript(
+	name, source string,
+	params *Params,
+	targets []*registry.Target,
+) (*script, error) {
+	globals, err := starlark.ExecFile(newThread(name), name, source, predeclared(params))
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["frame"].(starlark.Callable)
+	if !ok {
+		return nil, errNoFrameFunc
+	}
+	s := &script{
+		name:    name,
+		fn:      fn,
+		targets: make(map[registry.Target]int),
+	}
+	lamps := []starlark.Value{}
+	for i, t := range targets {
+		lamps = append(lamps, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"index":       starlark.MakeInt(i),
+			"provider_id": starlark.String(t.ProviderID),
+			"group_id":    starlark.String(t.GroupID),
+			"lamp_id":     starlark.String(t.LampID),
+		}))
+		s.targets[*t] = i
+	}
+	s.lamps = starlark.NewList(lamps)
+	s.lamps.Freeze()
+	return s, nil
+}
+
+func (s *script) Render(t time.Duration, lamps []*LampState) error {
+	thread := newThread(s.name)
+	timer := time.AfterFunc(scriptTimeout, func() {
+		thread.Cancel("frame took too long to compute")
+	})
+	defer timer.Stop()
+	v, err := starlark.Call(
+		thread,
+		s.fn,
+		starlark.Tuple{starlark.Float(t.Seconds()), s.lamps},
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	l, ok := v.(*starlark.List)
+	if !ok {
+		return errInvalidReturn
+	}
+	for i := 0; i < l.Len(); i++ {
+		d, ok := l.Index(i).(*starlark.Dict)
+		if !ok {
+			return errInvalidReturn
+		}
+		idx, c, err := s.parseChange(d)
+		if err != nil {
+			return err
+		}
+		if idx < 0 || idx >= len(lamps) {
+			return fmt.Errorf("lamp index %d is out of range", idx)
+		}
+		if !c.State {
+			lamps[idx].Off()
+			continue
+		}
+		var (
+			b   = c.Brightness
+			col = colorful.Color{R: 1, G: 1, B: 1}
+		)
+		if b == 0 {
+			b = 1
+		}
+		if c.Color != nil {
+			col = c.Color.Color
+		}
+		lamps[idx].Set(b, col)
+	}
+	return nil
+}
+
+// parseChange converts a dict returned by the script into the index of the
+// lamp and the change to apply to it.
+func (s *script) parseChange(d *starlark.Dict) (int, *registry.Change, error) {
+	var (
+		t   = registry.Target{}
+		c   = &registry.Change{}
+		idx = -1
+	)
+	for _, item := range d.Items() {
+		k, ok := starlark.AsString(item[0])
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid key %s in change", item[0])
+		}
+		v := item[1]
+		var err error
+		switch k {
+		case "index":
+			idx, err = starlark.AsInt32(v)
+		case "provider_id":
+			t.ProviderID, ok = starlark.AsString(v)
+		case "group_id":
+			t.GroupID, ok = starlark.AsString(v)
+		case "lamp_id":
+			t.LampID, ok = starlark.AsString(v)
+		case "state":
+			c.State = bool(v.Truth())
+		case "brightness":
+			c.Brightness, ok = starlark.AsFloat(v)
+		case "color":
+			var str string
+			if str, ok = starlark.AsString(v); ok {
+				c.Color, err = registry.ParseColor(str)
+			}
+		default:
+			ok = false
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid value for %s in change", k)
+		}
+	}
+	if idx == -1 {
+		i, ok := s.targets[t]
+		if !ok {
+			return 0, nil, fmt.Errorf(
+				"lamp %s/%s/%s is not part of the effect",
+				t.ProviderID, t.GroupID, t.LampID,
+			)
+		}
+		idx = i
+	}
+	return idx, c, nil
+}
@@ -12,6 +12,7 @@ require (
 	github.com/stianeikeland/go-rpio/v4 v4.6.0
 	github.com/urfave/cli/v2 v2.25.7
 	gitlab.com/gomidi/midi/v2 v2.0.30
+	go.starlark.net v0.0.0-20230525235612-a134d8f9ddca
 	gorm.io/driver/sqlite v1.5.4
 	gorm.io/gorm v1.25.5
 )
@@ -1,6 +1,7 @@
 package gpio
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"sync"
@@ -11,7 +12,10 @@ import (
 	"github.com/lampctl/lampctl/registry"
 )
 
-const ProviderID = "gpio"
+const (
+	ProviderID   = "gpio"
+	ProviderName = "GPIO Shift Register"
+)
 
 // GPIO implements the Provider interface for shift registers connected to
 // GPIO pins on a Raspberry Pi.
@@ -60,7 +64,7 @@ func (g *GPIO) ID() string {
 }
 
 func (g *GPIO) Name() string {
-	return "GPIO Shift Register"
+	return ProviderName
 }
 
 func (g *GPIO) Init(api *gin.RouterGroup) error {
@@ -105,32 +109,30 @@ func (g *GPIO) Lamps() []*registry.Lamp {
 	return lamps
 }
 
-func (g *GPIO) Apply(changes []*registry.Change) error {
+func (g *GPIO) Apply(ctx context.Context, changes []*registry.Change) []*registry.Result {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 	dirtyRegisters := make(map[*Register]interface{})
-	for _, c := range changes {
+	results := registry.ApplyEach(changes, func(c *registry.Change) error {
 		r, err := g.findRegister(c.GroupID)
 		if err != nil {
 			return err
 		}
 		v, err := strconv.ParseInt(c.LampID, 10, 64)
-		if err != nil {
-			return err
-		}
-		if v < 0 || v >= r.Width {
+		if err != nil || v < 0 || v >= r.Width {
 			return registry.ErrInvalidLamp
 		}
 		r.channels[v] = c.State
 		dirtyRegisters[r] = nil
-	}
+		return nil
+	})
 	for r := range dirtyRegisters {
 		r.Cycle()
 	}
-	return nil
+	return results
 }
 
-func (g *GPIO) ApplyToAll(change *registry.Change) error {
+func (g *GPIO) ApplyToAll(ctx context.Context, change *registry.Change) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 	for _, r := range g.registers {
@@ -8,7 +8,7 @@ import (
 	hue_db "github.com/lampctl/lampctl/hue/db"
 )
 
-var errInvalidBridge = errors.New("invalid bridge specified")
+var ErrInvalidBridge = errors.New("invalid bridge specified")
 
 func (h *Hue) api_hue_bridges_POST(c *gin.Context) {
 	v := &hue_db.Bridge{}
@@ -17,16 +17,16 @@ func (h *Hue) api_hue_bridges_POST(c *gin.Context) {
 	}
 	b := NewBridge(v)
 	if b.Username == "" {
-		if err := b.register(); err != nil {
+		if err := b.register(c.Request.Context()); err != nil {
 			panic(err)
 		}
 	}
 	if b.ID == "" {
-		if err := b.getID(); err != nil {
+		if err := b.getID(c.Request.Context()); err != nil {
 			panic(err)
 		}
 	}
-	if err := b.Init(); err != nil {
+	if err := b.Init(c.Request.Context()); err != nil {
 		panic(err)
 	}
 	if err := h.db.Save(b).Error; err != nil {
@@ -41,14 +41,14 @@ func (h *Hue) api_hue_bridges_POST(c *gin.Context) {
 }
 
 func (h *Hue) api_hue_bridges_id_DELETE(c *gin.Context) {
-	if err := h.db.Delete(&hue_db.Bridge{}, c.Param("id")); err != nil {
+	if err := h.db.Delete(&hue_db.Bridge{}, "id = ?", c.Param("id")).Error; err != nil {
 		panic(err)
 	}
 	func() {
 		h.mutex.Lock()
 		defer h.mutex.Unlock()
 		if _, ok := h.bridges[c.Param("id")]; !ok {
-			panic(errInvalidBridge)
+			panic(ErrInvalidBridge)
 		}
 		delete(h.bridges, c.Param("id"))
 	}()
@@ -2,6 +2,7 @@ package hue
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -9,17 +10,16 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	hue_db "github.com/lampctl/lampctl/hue/db"
-	"github.com/lucasb-eyer/go-colorful"
+	"github.com/lampctl/lampctl/registry"
 )
 
 const appName = "lampctl"
 
-var (
-	errInvalidResource = errors.New("invalid resource specified")
-	errInvalidResponse = errors.New("invalid response received")
-)
+var errInvalidResponse = errors.New("invalid response received")
 
 type bridgeResource struct {
 	Name     string
@@ -27,23 +27,59 @@ type bridgeResource struct {
 	Resource *hueResource
 }
 
-// Bridge represents a connection to a Hue bridge.
+// Bridge represents a connection to a Hue bridge. The mutex guards the
+// resources, which are updated after each request; it is never held while a
+// request is in progress. If the bridge could not be enumerated, err is set
+// and the bridge is unavailable until it is enumerated successfully.
 type Bridge struct {
 	*hue_db.Bridge
+	mutex         sync.RWMutex
 	client        *http.Client
 	resources     map[string]*bridgeResource
 	allResourceID string
+	err           error
+	since         time.Time
+}
+
+// setErr marks the bridge as unavailable because of the error or, if it is
+// nil, as available again.
+func (b *Bridge) setErr(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil {
+		b.err = nil
+		return
+	}
+	if b.err == nil {
+		b.since = time.Now()
+	}
+	b.err = err
+}
+
+// unavailable returns when the bridge first failed and the error that made
+// it unavailable or a nil error if the bridge is available.
+func (b *Bridge) unavailable() (time.Time, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.since, b.err
 }
 
 func (b *Bridge) getResource(id string) (*bridgeResource, error) {
+	if b.err != nil {
+		return nil, registry.ErrProviderUnavailable
+	}
 	r, ok := b.resources[id]
 	if !ok {
-		return nil, errInvalidResource
+		return nil, registry.ErrInvalidLamp
 	}
 	return r, nil
 }
 
-func (b *Bridge) doRequest(method, path string, body interface{}) (*http.Response, error) {
+func (b *Bridge) doRequest(
+	ctx context.Context,
+	method, path string,
+	body interface{},
+) (*http.Response, error) {
 	var reader io.Reader
 	if body != nil {
 		v, err := json.Marshal(body)
@@ -57,7 +93,7 @@ func (b *Bridge) doRequest(method, path string, body interface{}) (*http.Respons
 		Host:   b.Host,
 		Path:   path,
 	}
-	r, err := http.NewRequest(method, u.String(), reader)
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
 	if err != nil {
 		return nil, err
 	}
@@ -65,8 +101,12 @@ func (b *Bridge) doRequest(method, path string, body interface{}) (*http.Respons
 	return b.client.Do(r)
 }
 
-func (b *Bridge) doRequestAndResponse(method, path string, body interface{}) (*hueResponse, error) {
-	r, err := b.doRequest(method, path, body)
+func (b *Bridge) doRequestAndResponse(
+	ctx context.Context,
+	method, path string,
+	body interface{},
+) (*hueResponse, error) {
+	r, err := b.doRequest(ctx, method, path, body)
 	if err != nil {
 		return nil, err
 	}
@@ -78,16 +118,17 @@ func (b *Bridge) doRequestAndResponse(method, path string, body interface{}) (*h
 	return response, nil
 }
 
-func (b *Bridge) doGet(method string) (*hueResponse, error) {
-	return b.doRequestAndResponse(http.MethodGet, method, nil)
+func (b *Bridge) doGet(ctx context.Context, method string) (*hueResponse, error) {
+	return b.doRequestAndResponse(ctx, http.MethodGet, method, nil)
 }
 
-func (b *Bridge) doPut(method string, body interface{}) (*hueResponse, error) {
-	return b.doRequestAndResponse(http.MethodPut, method, body)
+func (b *Bridge) doPut(ctx context.Context, method string, body interface{}) (*hueResponse, error) {
+	return b.doRequestAndResponse(ctx, http.MethodPut, method, body)
 }
 
-func (b *Bridge) register() error {
+func (b *Bridge) register(ctx context.Context) error {
 	r, err := b.doRequest(
+		ctx,
 		http.MethodPost,
 		"/api",
 		&hueRegisterRequest{
@@ -116,8 +157,8 @@ func (b *Bridge) register() error {
 	return nil
 }
 
-func (b *Bridge) getID() error {
-	r, err := b.doGet("/clip/v2/resource/bridge")
+func (b *Bridge) getID(ctx context.Context) error {
+	r, err := b.doGet(ctx, "/clip/v2/resource/bridge")
 	if err != nil {
 		return err
 	}
@@ -133,14 +174,17 @@ func (b *Bridge) getID() error {
 }
 
 func (b *Bridge) setState(
+	ctx context.Context,
 	light_id string,
 	on bool,
 	brightness float64,
-	color string,
+	color *registry.Color,
 	duration int64,
 ) error {
+	b.mutex.RLock()
 	r, err := b.getResource(light_id)
 	if err != nil {
+		b.mutex.RUnlock()
 		return err
 	}
 	if brightness == 0 {
@@ -150,38 +194,61 @@ func (b *Bridge) setState(
 		On: &hueOn{
 			On: on,
 		},
-		Dimming: &hueDimming{
-			Brightness: brightness,
-		},
 		Dynamics: &hueDynamics{
 			Duration: duration,
 		},
 	}
 	if on {
 		l.Dimming = &hueDimming{
-			Brightness: 100,
+			Brightness: brightness * 100,
 		}
 	}
-	if color != "" {
-		c, err := colorful.Hex(color)
-		if err != nil {
-			return err
-		}
-		x, y, _ := c.Xyy()
-		l.Color = &hueColor{
-			XY: &hueColorXY{
-				X: x,
-				Y: y,
-			},
-		}
+	if color != nil {
+		convertColor(r.Resource, color, l)
 	}
-	if _, err := b.doPut(r.Path, l); err != nil {
+	b.mutex.RUnlock()
+	if _, err := b.doPut(ctx, r.Path, l); err != nil {
 		return err
 	}
-	l.On.On = on
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if r.Resource.On != nil {
+		r.Resource.On.On = on
+	}
 	return nil
 }
 
+// lamps returns each of the lights and grouped lights on the bridge or none
+// if it is unavailable.
+func (b *Bridge) lamps() []*registry.Lamp {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	lamps := []*registry.Lamp{}
+	if b.err != nil {
+		return lamps
+	}
+	for _, r := range b.resources {
+		l := &registry.Lamp{
+			ID:      r.Resource.ID,
+			Name:    r.Name,
+			GroupID: fmt.Sprint(b.ID),
+		}
+		if r.Resource.On != nil {
+			l.State = r.Resource.On.On
+		}
+		lamps = append(lamps, l)
+	}
+	return lamps
+}
+
+// setAllState changes the state of every light on the bridge.
+func (b *Bridge) setAllState(ctx context.Context, c *registry.Change) error {
+	b.mutex.RLock()
+	id := b.allResourceID
+	b.mutex.RUnlock()
+	return b.setState(ctx, id, c.State, c.Brightness, c.Color, c.Duration)
+}
+
 // NewBridge creates a new Bridge instance.
 func NewBridge(bridge *hue_db.Bridge) *Bridge {
 	return &Bridge{
@@ -203,8 +270,8 @@ func NewBridge(bridge *hue_db.Bridge) *Bridge {
 
 // Init enumerates the contents of the bridge, looking for lights and grouped
 // lights, extracting information from what is retrieved.
-func (b *Bridge) Init() error {
-	r, err := b.doGet("/clip/v2/resource")
+func (b *Bridge) Init(ctx context.Context) error {
+	r, err := b.doGet(ctx, "/clip/v2/resource")
 	if err != nil {
 		return err
 	}
@@ -222,12 +289,16 @@ func (b *Bridge) Init() error {
 		}
 	}
 
+	var (
+		bridgeResources = make(map[string]*bridgeResource)
+		allResourceID   string
+	)
 	for _, r := range resources {
 		switch r.Type {
 
 		// For a light, simply add it to the map by its ID
 		case hueTypeLight:
-			b.resources[r.ID] = &bridgeResource{
+			bridgeResources[r.ID] = &bridgeResource{
 				Name:     r.Metadata.Name,
 				Path:     fmt.Sprintf("/clip/v2/resource/light/%s", r.ID),
 				Resource: r,
@@ -238,14 +309,19 @@ func (b *Bridge) Init() error {
 			name := nameMap[r.Owner.RID]
 			if r.Owner.RType == hueTypeBridgeHome {
 				name = "All"
-				b.allResourceID = r.ID
+				allResourceID = r.ID
 			}
-			b.resources[r.ID] = &bridgeResource{
+			bridgeResources[r.ID] = &bridgeResource{
 				Name:     name,
 				Path:     fmt.Sprintf("/clip/v2/resource/grouped_light/%s", r.ID),
 				Resource: r,
 			}
 		}
 	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.resources = bridgeResources
+	b.allResourceID = allResourceID
+	b.err = nil
 	return nil
 }
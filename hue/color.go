@@ -0,0 +1,102 @@
+package hue
+
+import (
+	"github.com/lampctl/lampctl/registry"
+)
+
+const (
+	minMirek = 153
+	maxMirek = 500
+)
+
+// defaultGamut is used for lights that do not report their own gamut; it
+// corresponds to gamut C, which is used by most current color bulbs.
+var defaultGamut = &hueGamut{
+	Red:   &hueColorXY{X: 0.6915, Y: 0.3083},
+	Green: &hueColorXY{X: 0.17, Y: 0.7},
+	Blue:  &hueColorXY{X: 0.1532, Y: 0.0475},
+}
+
+func crossProduct(a, b *hueColorXY) float64 {
+	return a.X*b.Y - a.Y*b.X
+}
+
+// closestPointOnLine finds the point on the line segment a-b nearest to p.
+func closestPointOnLine(a, b, p *hueColorXY) *hueColorXY {
+	var (
+		ap  = &hueColorXY{X: p.X - a.X, Y: p.Y - a.Y}
+		ab  = &hueColorXY{X: b.X - a.X, Y: b.Y - a.Y}
+		ab2 = ab.X*ab.X + ab.Y*ab.Y
+		t   = (ap.X*ab.X + ap.Y*ab.Y) / ab2
+	)
+	if t < 0 {
+		t = 0
+	}
+	if t > 1 {
+		t = 1
+	}
+	return &hueColorXY{X: a.X + ab.X*t, Y: a.Y + ab.Y*t}
+}
+
+func distanceSquared(a, b *hueColorXY) float64 {
+	dx, dy := a.X-b.X, a.Y-b.Y
+	return dx*dx + dy*dy
+}
+
+// clamp returns the point inside the gamut triangle nearest to the provided
+// xy coordinates.
+func (g *hueGamut) clamp(p *hueColorXY) *hueColorXY {
+	var (
+		v1 = &hueColorXY{X: g.Green.X - g.Red.X, Y: g.Green.Y - g.Red.Y}
+		v2 = &hueColorXY{X: g.Blue.X - g.Red.X, Y: g.Blue.Y - g.Red.Y}
+		q  = &hueColorXY{X: p.X - g.Red.X, Y: p.Y - g.Red.Y}
+		d  = crossProduct(v1, v2)
+		s  = crossProduct(q, v2) / d
+		t  = crossProduct(v1, q) / d
+	)
+	if s >= 0 && t >= 0 && s+t <= 1 {
+		return p
+	}
+	var (
+		best     *hueColorXY
+		bestDist float64
+	)
+	for _, edge := range [][2]*hueColorXY{
+		{g.Red, g.Green},
+		{g.Green, g.Blue},
+		{g.Blue, g.Red},
+	} {
+		c := closestPointOnLine(edge[0], edge[1], p)
+		if d := distanceSquared(c, p); best == nil || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// convertColor converts a color into the representation the light supports.
+// Color temperatures are sent as mirek when the light supports them and all
+// other colors are converted to xy coordinates within the light's gamut.
+func convertColor(r *hueResource, c *registry.Color, l *hueResource) {
+	if c.Kelvin != 0 && (r.Type == hueTypeGroupedLight || r.ColorTemperature != nil) {
+		m := c.Mirek()
+		if m < minMirek {
+			m = minMirek
+		}
+		if m > maxMirek {
+			m = maxMirek
+		}
+		l.ColorTemperature = &hueColorTemperature{
+			Mirek: m,
+		}
+		return
+	}
+	g := defaultGamut
+	if r.Color != nil && r.Color.Gamut != nil {
+		g = r.Color.Gamut
+	}
+	x, y := c.XY()
+	l.Color = &hueColor{
+		XY: g.clamp(&hueColorXY{X: x, Y: y}),
+	}
+}
@@ -1,30 +1,133 @@
 package hue
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lampctl/lampctl/db"
 	hue_db "github.com/lampctl/lampctl/hue/db"
 	"github.com/lampctl/lampctl/registry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
-const ProviderID = "hue"
+const (
+	ProviderID   = "hue"
+	ProviderName = "Philips Hue"
+
+	// initTimeout limits how long enumerating a bridge may take
+	initTimeout = 30 * time.Second
+)
+
+// initBridge enumerates the bridge, giving up after initTimeout.
+func initBridge(b *Bridge) error {
+	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	defer cancel()
+	return b.Init(ctx)
+}
 
 // Hue implements the Provider interface for Philips Hue wireless products that
-// are connected to a bridge accessible over the network.
+// are connected to a bridge accessible over the network. Bridges that cannot
+// be reached remain in the list as unavailable and are retried in the
+// background so that the others keep working.
 type Hue struct {
-	mutex   sync.RWMutex
-	db      *db.Conn
-	bridges map[string]*Bridge
+	mutex      sync.RWMutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	bridges    map[string]*Bridge
+	nextRetry  time.Time
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func (h *Hue) run() {
+	defer close(h.closedChan)
+	retry := registry.NextRetryInterval(0)
+	for {
+		var timer <-chan time.Time
+		func() {
+			h.mutex.Lock()
+			defer h.mutex.Unlock()
+			h.nextRetry = time.Time{}
+			if h.hasUnavailable() {
+				h.nextRetry = time.Now().Add(retry)
+				timer = time.After(retry)
+			}
+		}()
+		select {
+		case <-timer:
+			if h.retryUnavailable() {
+				retry = registry.NextRetryInterval(0)
+			} else {
+				retry = registry.NextRetryInterval(retry)
+			}
+		case <-h.wakeChan:
+		case <-h.closeChan:
+			return
+		}
+	}
 }
 
-// New creates a new Hue instance.
+func (h *Hue) wake() {
+	select {
+	case h.wakeChan <- nil:
+	default:
+	}
+}
+
+// hasUnavailable determines whether any bridges are unavailable. The mutex
+// must be held.
+func (h *Hue) hasUnavailable() bool {
+	for _, b := range h.bridges {
+		if _, err := b.unavailable(); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// retryUnavailable attempts to enumerate each of the bridges that are
+// unavailable, returning true if all of them succeeded.
+func (h *Hue) retryUnavailable() bool {
+	ok := true
+	for _, b := range h.bridgeList() {
+		if _, err := b.unavailable(); err == nil {
+			continue
+		}
+		if err := initBridge(b); err != nil {
+			b.setErr(err)
+			ok = false
+			continue
+		}
+		h.logger.Info().Str("bridge", b.ID).Msg("bridge is now available")
+	}
+	return ok
+}
+
+// fail marks the bridge as unavailable and wakes the goroutine so that it is
+// retried.
+func (h *Hue) fail(b *Bridge, err error) {
+	h.logger.Error().Str("bridge", b.ID).Msg(err.Error())
+	b.setErr(err)
+	h.wake()
+}
+
+// New creates a new Hue instance. Bridges that cannot be reached are logged
+// and retried in the background.
 func New(cfg *Config) (*Hue, error) {
 	h := &Hue{
-		db:      cfg.DB,
-		bridges: make(map[string]*Bridge),
+		logger:     log.With().Str("package", "hue").Logger(),
+		db:         cfg.DB,
+		bridges:    make(map[string]*Bridge),
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
 	}
 	if err := h.db.AutoMigrate(&hue_db.Bridge{}); err != nil {
 		return nil, err
@@ -35,11 +138,12 @@ func New(cfg *Config) (*Hue, error) {
 	}
 	for _, b := range bridges {
 		v := NewBridge(b)
-		if err := v.Init(); err != nil {
-			return nil, err
+		if err := initBridge(v); err != nil {
+			h.fail(v, err)
 		}
 		h.bridges[b.ID] = v
 	}
+	go h.run()
 	return h, nil
 }
 
@@ -48,7 +152,7 @@ func (h *Hue) ID() string {
 }
 
 func (h *Hue) Name() string {
-	return "Philips Hue"
+	return ProviderName
 }
 
 func (h *Hue) Init(api *gin.RouterGroup) error {
@@ -57,7 +161,48 @@ func (h *Hue) Init(api *gin.RouterGroup) error {
 	return nil
 }
 
-func (h *Hue) Close() {}
+func (h *Hue) Close() {
+	close(h.closeChan)
+	<-h.closedChan
+}
+
+// Health reports the provider as degraded if any bridges are unavailable.
+func (h *Hue) Health() *registry.Health {
+	var (
+		health = &registry.Health{
+			Status: registry.HealthOK,
+		}
+		errs = []string{}
+	)
+	for _, b := range h.bridgeList() {
+		since, err := b.unavailable()
+		if err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Sprintf("bridge %s: %s", b.ID, err))
+		if health.Since.IsZero() || since.Before(health.Since) {
+			health.Since = since
+		}
+	}
+	if len(errs) == 0 {
+		return health
+	}
+	sort.Strings(errs)
+	health.Status = registry.HealthDegraded
+	health.Error = strings.Join(errs, "; ")
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if !h.nextRetry.IsZero() {
+		nextRetry := h.nextRetry
+		health.NextRetry = &nextRetry
+	}
+	return health
+}
+
+// NativeTransitions indicates that the bridge handles fading itself.
+func (h *Hue) NativeTransitions() bool {
+	return true
+}
 
 func (h *Hue) Groups() []*registry.Group {
 	h.mutex.RLock()
@@ -73,50 +218,74 @@ func (h *Hue) Groups() []*registry.Group {
 }
 
 func (h *Hue) Lamps() []*registry.Lamp {
+	lights := []*registry.Lamp{}
+	for _, b := range h.bridgeList() {
+		lights = append(lights, b.lamps()...)
+	}
+	return lights
+}
+
+// bridgeList returns a copy of the current bridges so that requests can be
+// made to them without holding the mutex.
+func (h *Hue) bridgeList() []*Bridge {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
-	lights := []*registry.Lamp{}
+	bridges := []*Bridge{}
 	for _, b := range h.bridges {
-		for _, r := range b.resources {
-			lights = append(lights, &registry.Lamp{
-				ID:      r.Resource.ID,
-				Name:    r.Name,
-				GroupID: fmt.Sprint(b.ID),
-				State:   r.Resource.On.On,
-			})
-		}
+		bridges = append(bridges, b)
 	}
-	return lights
+	return bridges
 }
 
-func (h *Hue) Apply(changes []*registry.Change) error {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	for _, c := range changes {
-		b, ok := h.bridges[c.GroupID]
-		if !ok {
-			return registry.ErrInvalidGroup
-		}
-		if err := b.setState(c.LampID, c.State, c.Brightness, c.Color, c.Duration); err != nil {
+func (h *Hue) getBridge(id string) (*Bridge, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	b, ok := h.bridges[id]
+	if !ok {
+		return nil, registry.ErrInvalidGroup
+	}
+	return b, nil
+}
+
+func (h *Hue) Apply(ctx context.Context, changes []*registry.Change) []*registry.Result {
+	return registry.ApplyEach(changes, func(c *registry.Change) error {
+		b, err := h.getBridge(c.GroupID)
+		if err != nil {
 			return err
 		}
+		return b.setState(ctx, c.LampID, c.State, c.Brightness, c.Color, c.Duration)
+	})
+}
+
+// Refresh enumerates each bridge again so that changes made with a switch or
+// the Hue app are reflected in the state of the lamps. Bridges that fail are
+// marked as unavailable and their lamps are not reported until they are
+// retried successfully.
+func (h *Hue) Refresh(ctx context.Context) error {
+	for _, b := range h.bridgeList() {
+		if _, err := b.unavailable(); err != nil {
+			continue
+		}
+		if err := b.Init(ctx); err != nil {
+			h.fail(b, err)
+		}
 	}
 	return nil
 }
 
-func (h *Hue) ApplyToAll(change *registry.Change) error {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	for _, b := range h.bridges {
-		if err := b.setState(
-			b.allResourceID,
-			change.State,
-			change.Brightness,
-			change.Color,
-			change.Duration,
-		); err != nil {
+// ApplyToAll changes every light on each of the bridges. Bridges that are
+// unavailable are skipped, in which case ErrProviderUnavailable is returned
+// once the others have been changed.
+func (h *Hue) ApplyToAll(ctx context.Context, change *registry.Change) error {
+	var skipped error
+	for _, b := range h.bridgeList() {
+		if _, err := b.unavailable(); err != nil {
+			skipped = registry.ErrProviderUnavailable
+			continue
+		}
+		if err := b.setAllState(ctx, change); err != nil {
 			return err
 		}
 	}
-	return nil
+	return skipped
 }
@@ -52,8 +52,19 @@ type hueColorXY struct {
 	Y float64 `json:"y"`
 }
 
+type hueGamut struct {
+	Red   *hueColorXY `json:"red"`
+	Green *hueColorXY `json:"green"`
+	Blue  *hueColorXY `json:"blue"`
+}
+
 type hueColor struct {
-	XY *hueColorXY `json:"xy"`
+	XY    *hueColorXY `json:"xy"`
+	Gamut *hueGamut   `json:"gamut,omitempty"`
+}
+
+type hueColorTemperature struct {
+	Mirek int `json:"mirek"`
 }
 
 type hueDynamics struct {
@@ -61,14 +72,15 @@ type hueDynamics struct {
 }
 
 type hueResource struct {
-	ID       string       `json:"id,omitempty"`
-	Owner    *hueOwner    `json:"owner,omitempty"`
-	Metadata *hueMetadata `json:"metadata,omitempty"`
-	On       *hueOn       `json:"on,omitempty"`
-	Dimming  *hueDimming  `json:"dimming,omitempty"`
-	Color    *hueColor    `json:"color,omitempty"`
-	Dynamics *hueDynamics `json:"dynamics,omitempty"`
-	Type     string       `json:"type,omitempty"`
+	ID               string               `json:"id,omitempty"`
+	Owner            *hueOwner            `json:"owner,omitempty"`
+	Metadata         *hueMetadata         `json:"metadata,omitempty"`
+	On               *hueOn               `json:"on,omitempty"`
+	Dimming          *hueDimming          `json:"dimming,omitempty"`
+	Color            *hueColor            `json:"color,omitempty"`
+	ColorTemperature *hueColorTemperature `json:"color_temperature,omitempty"`
+	Dynamics         *hueDynamics         `json:"dynamics,omitempty"`
+	Type             string               `json:"type,omitempty"`
 }
 
 type hueBridge struct {
@@ -5,13 +5,20 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/lampctl/lampctl/automation"
 	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/effects"
 	"github.com/lampctl/lampctl/gpio"
 	"github.com/lampctl/lampctl/hue"
+	"github.com/lampctl/lampctl/plugins"
+	"github.com/lampctl/lampctl/presence"
 	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
 	"github.com/lampctl/lampctl/sequencer"
 	"github.com/lampctl/lampctl/server"
+	"github.com/lampctl/lampctl/virtual"
 	"github.com/lampctl/lampctl/ws2811"
 	"github.com/urfave/cli/v2"
 )
@@ -37,6 +44,34 @@ func main() {
 				EnvVars: []string{"SERVER_ADDR"},
 				Usage:   "HTTP address to listen on",
 			},
+			&cli.IntFlag{
+				Name:    "frame-rate",
+				Value:   30,
+				EnvVars: []string{"FRAME_RATE"},
+				Usage:   "frames per second for rendering transitions and effects",
+			},
+			&cli.IntFlag{
+				Name:    "reconcile-interval",
+				Value:   30,
+				EnvVars: []string{"RECONCILE_INTERVAL"},
+				Usage:   "seconds between checks for lamps changed externally",
+			},
+			&cli.StringFlag{
+				Name:    "plugin-dir",
+				EnvVars: []string{"PLUGIN_DIR"},
+				Usage:   "directory containing plugin manifests",
+			},
+			&cli.StringSliceFlag{
+				Name:    "notify-host",
+				EnvVars: []string{"NOTIFY_HOSTS"},
+				Usage:   "host that automation rules may send notifications to",
+			},
+			&cli.IntFlag{
+				Name:    "history-retention",
+				Value:   30,
+				EnvVars: []string{"HISTORY_RETENTION"},
+				Usage:   "days to keep the history of changes",
+			},
 		},
 		Commands: []*cli.Command{
 			installCommand,
@@ -53,50 +88,141 @@ func main() {
 			defer db.Close()
 
 			// Create the registry
-			r := registry.New()
-			defer r.Close()
-
-			// Add the currently-supported providers
-
-			// GPIO
-			g, err := gpio.New(&gpio.Config{
-				DB: db,
+			r, err := registry.New(&registry.Config{
+				DB:                db,
+				FrameRate:         c.Int("frame-rate"),
+				ReconcileInterval: time.Duration(c.Int("reconcile-interval")) * time.Second,
+				HistoryRetention:  time.Duration(c.Int("history-retention")) * 24 * time.Hour,
 			})
 			if err != nil {
 				return err
 			}
-			r.Register(g)
+			defer r.Close()
+
+			// Add the currently-supported providers; each is supervised so
+			// that a failure does not prevent the rest of the application from
+			// starting and can be enabled or disabled at runtime
+			for _, d := range []*registry.Definition{
+				{
+					ID:      gpio.ProviderID,
+					Name:    gpio.ProviderName,
+					Timeout: time.Second,
+					Factory: func() (registry.Provider, error) {
+						return gpio.New(&gpio.Config{
+							DB: db,
+						})
+					},
+				},
+				{
+					ID:      hue.ProviderID,
+					Name:    hue.ProviderName,
+					Timeout: 5 * time.Second,
+					Factory: func() (registry.Provider, error) {
+						return hue.New(&hue.Config{
+							DB: db,
+						})
+					},
+				},
+				{
+					ID:      ws2811.ProviderID,
+					Name:    ws2811.ProviderName,
+					Timeout: time.Second,
+					Factory: func() (registry.Provider, error) {
+						return ws2811.New(&ws2811.Config{
+							DB: db,
+						})
+					},
+				},
+				{
+					ID:      virtual.ProviderID,
+					Name:    virtual.ProviderName,
+					Timeout: time.Second,
+					Factory: func() (registry.Provider, error) {
+						return virtual.New(&virtual.Config{
+							DB: db,
+						})
+					},
+				},
+			} {
+				if err := r.Define(d); err != nil {
+					return err
+				}
+			}
 
-			// Hue
-			h, err := hue.New(&hue.Config{
-				DB: db,
+			// Load the external provider plugins
+			pm, err := plugins.New(&plugins.Config{
+				Dir:      c.String("plugin-dir"),
+				Registry: r,
 			})
 			if err != nil {
 				return err
 			}
-			r.Register(h)
 
-			// ws2811
-			w, err := ws2811.New(&ws2811.Config{
-				DB: db,
+			// Create the effects engine
+			e, err := effects.New(&effects.Config{
+				DB:        db,
+				Registry:  r,
+				FrameRate: c.Int("frame-rate"),
 			})
 			if err != nil {
 				return err
 			}
-			r.Register(w)
+			defer e.Close()
 
 			// Create the sequencer
 			seq := sequencer.New(&sequencer.Config{
 				Registry: r,
+				Effects:  e,
 			})
 			defer seq.Close()
 
-			// Start up the server
-			s, err := server.New(&server.Config{
-				Addr:      c.String("server-addr"),
-				Debug:     c.Bool("debug"),
+			// Create the presence simulation
+			pr, err := presence.New(&presence.Config{
+				DB:       db,
+				Registry: r,
+			})
+			if err != nil {
+				return err
+			}
+			defer pr.Close()
+
+			// Create the scheduler
+			sch, err := scheduler.New(&scheduler.Config{
+				DB:        db,
 				Registry:  r,
+				Effects:   e,
 				Sequencer: seq,
+				Presence:  pr,
+			})
+			if err != nil {
+				return err
+			}
+			defer sch.Close()
+
+			// Create the automation engine
+			a, err := automation.New(&automation.Config{
+				DB:          db,
+				Registry:    r,
+				Sequencer:   seq,
+				Scheduler:   sch,
+				NotifyHosts: c.StringSlice("notify-host"),
+			})
+			if err != nil {
+				return err
+			}
+			defer a.Close()
+
+			// Start up the server
+			s, err := server.New(&server.Config{
+				Addr:       c.String("server-addr"),
+				Debug:      c.Bool("debug"),
+				Registry:   r,
+				Effects:    e,
+				Sequencer:  seq,
+				Scheduler:  sch,
+				Automation: a,
+				Presence:   pr,
+				Plugins:    pm,
 			})
 			if err != nil {
 				return err
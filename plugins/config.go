@@ -0,0 +1,12 @@
+package plugins
+
+import (
+	"github.com/lampctl/lampctl/registry"
+)
+
+// Config provides the configuration for the plugin manager. Plugins are
+// loaded from the manifests in Dir; if it is empty, no plugins are loaded.
+type Config struct {
+	Dir      string
+	Registry *registry.Registry
+}
@@ -0,0 +1,48 @@
+package plugins
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const manifestExt = ".json"
+
+// Manifest describes an external provider executable. If Socket is empty,
+// the plugin communicates over stdin and stdout; otherwise lampctl connects
+// to the Unix socket, starting Command first if provided. Relative paths are
+// resolved against the plugin directory.
+type Manifest struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Socket  string   `json:"socket"`
+}
+
+// loadManifest reads the manifest from the file, using its name (without the
+// extension) as the ID.
+func loadManifest(dir, name string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	v := &Manifest{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	v.ID = strings.TrimSuffix(name, manifestExt)
+	if v.Command == "" && v.Socket == "" {
+		return nil, ErrMissingFields
+	}
+	if v.Name == "" {
+		v.Name = v.ID
+	}
+	for _, p := range []*string{&v.Command, &v.Socket} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return v, nil
+}
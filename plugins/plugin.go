@@ -0,0 +1,382 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/rs/zerolog"
+)
+
+const (
+	methodGroups       = "groups"
+	methodLamps        = "lamps"
+	methodApply        = "apply"
+	methodApplyToAll   = "apply_to_all"
+	notifyStateChanged = "state_changed"
+	notifyInputPressed = "input_pressed"
+
+	// rpcTimeout limits calls that are not made on behalf of a request
+	rpcTimeout    = 10 * time.Second
+	socketTimeout = 10 * time.Second
+)
+
+type applyParams struct {
+	Changes []*registry.Change `json:"changes"`
+}
+
+type applyToAllParams struct {
+	Change *registry.Change `json:"change"`
+}
+
+type stateChangedParams struct {
+	Lamps []*registry.Lamp `json:"lamps"`
+}
+
+type inputPressedParams struct {
+	Input  string `json:"input"`
+	Action string `json:"action"`
+}
+
+// Plugin implements the Provider interface by forwarding each call to an
+// external process using JSON-RPC 2.0. The process is expected to implement
+// the following methods, mirroring the Provider interface:
+//
+//   - groups: no params, returns a list of groups
+//   - lamps: no params, returns a list of lamps
+//   - apply: {"changes": [...]}, returns a list of results, one per change
+//   - apply_to_all: {"change": {...}}, returns null
+//
+// The plugin may send a state_changed notification with {"lamps": [...]}
+// whenever the state of its lamps changes outside of lampctl and an
+// input_pressed notification with {"input": "...", "action": "..."} when an
+// input such as a button is used. If the process exits or the connection is
+// lost, it is restarted with exponential backoff.
+type Plugin struct {
+	mutex      sync.RWMutex
+	logger     zerolog.Logger
+	registry   *registry.Registry
+	plugin     *Manifest
+	conn       *rpcConn
+	groups     []*registry.Group
+	lamps      []*registry.Lamp
+	health     *registry.Health
+	closeChan  chan any
+	closedChan chan any
+}
+
+func newPlugin(
+	logger zerolog.Logger,
+	r *registry.Registry,
+	p *Manifest,
+) *Plugin {
+	v := &Plugin{
+		logger:   logger.With().Str("plugin", p.ID).Logger(),
+		registry: r,
+		plugin:   p,
+		health: &registry.Health{
+			Status: registry.HealthDegraded,
+			Error:  "plugin is starting",
+			Since:  time.Now(),
+		},
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	go v.run()
+	return v
+}
+
+func (p *Plugin) handleNotification(method string, params json.RawMessage) {
+	switch method {
+	case notifyStateChanged:
+		v := &stateChangedParams{}
+		if err := json.Unmarshal(params, v); err != nil {
+			p.logger.Error().Msg(err.Error())
+			return
+		}
+		p.updateLamps(v.Lamps)
+	case notifyInputPressed:
+		v := &inputPressedParams{}
+		if err := json.Unmarshal(params, v); err != nil {
+			p.logger.Error().Msg(err.Error())
+			return
+		}
+		p.registry.PressInput(p.plugin.ID, v.Input, v.Action)
+	default:
+		p.logger.Warn().Str("method", method).Msg("unknown notification")
+	}
+}
+
+// updateLamps replaces the cached state of the provided lamps.
+func (p *Plugin) updateLamps(lamps []*registry.Lamp) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, l := range lamps {
+		found := false
+		for i, o := range p.lamps {
+			if o.GroupID == l.GroupID && o.ID == l.ID {
+				p.lamps[i] = l
+				found = true
+				break
+			}
+		}
+		if !found {
+			p.lamps = append(p.lamps, l)
+		}
+	}
+}
+
+// connect starts the process (if necessary) and establishes the connection.
+func (p *Plugin) connect() (*rpcConn, *exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if p.plugin.Command != "" {
+		cmd = exec.Command(p.plugin.Command, p.plugin.Args...)
+		cmd.Stderr = p.logger.With().Str("stream", "stderr").Logger()
+	}
+	if p.plugin.Socket == "" {
+		if cmd == nil {
+			return nil, nil, fmt.Errorf("plugin %s has no command or socket", p.plugin.ID)
+		}
+		w, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return newRPCConn(r, w, p.handleNotification), cmd, nil
+	}
+	if cmd != nil {
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The process may take a moment to create the socket
+	var (
+		deadline = time.Now().Add(socketTimeout)
+		conn     net.Conn
+		err      error
+	)
+	for {
+		conn, err = net.Dial("unix", p.plugin.Socket)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-p.closeChan:
+			err = registry.ErrProviderUnavailable
+		}
+		if err == registry.ErrProviderUnavailable {
+			break
+		}
+	}
+	if err != nil {
+		if cmd != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		return nil, nil, err
+	}
+	return newRPCConn(conn, conn, p.handleNotification), cmd, nil
+}
+
+// session runs the plugin until the connection is lost or the plugin is
+// closed. It returns nil only in the latter case.
+func (p *Plugin) session() error {
+	conn, cmd, err := p.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		conn.close()
+		if cmd != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.conn = nil
+		p.groups = nil
+		p.lamps = nil
+	}()
+	var (
+		groups = []*registry.Group{}
+		lamps  = []*registry.Lamp{}
+	)
+	if err := callWithTimeout(conn, methodGroups, &groups); err != nil {
+		return err
+	}
+	if err := callWithTimeout(conn, methodLamps, &lamps); err != nil {
+		return err
+	}
+	func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.conn = conn
+		p.groups = groups
+		p.lamps = lamps
+		p.health = &registry.Health{
+			Status: registry.HealthOK,
+			Since:  time.Now(),
+		}
+	}()
+	p.logger.Info().Msg("plugin started")
+	select {
+	case <-conn.closedChan:
+		return fmt.Errorf("plugin %s disconnected", p.plugin.ID)
+	case <-p.closeChan:
+		return nil
+	}
+}
+
+func (p *Plugin) run() {
+	defer close(p.closedChan)
+	retry := registry.NextRetryInterval(0)
+	for {
+		started := time.Now()
+		err := p.session()
+		if err == nil {
+			return
+		}
+		p.logger.Error().Msg(err.Error())
+
+		// Reset the backoff if the plugin ran for a while before failing
+		if time.Since(started) > 2*retry {
+			retry = registry.NextRetryInterval(0)
+		}
+		func() {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			next := time.Now().Add(retry)
+			p.health = &registry.Health{
+				Status:    registry.HealthDegraded,
+				Error:     err.Error(),
+				Since:     time.Now(),
+				NextRetry: &next,
+			}
+		}()
+		select {
+		case <-time.After(retry):
+		case <-p.closeChan:
+			return
+		}
+		retry = registry.NextRetryInterval(retry)
+	}
+}
+
+// callWithTimeout invokes a method without parameters, giving up after
+// rpcTimeout.
+func callWithTimeout(c *rpcConn, method string, result interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	return c.call(ctx, method, nil, result)
+}
+
+func (p *Plugin) getConn() (*rpcConn, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.conn == nil {
+		return nil, registry.ErrProviderUnavailable
+	}
+	return p.conn, nil
+}
+
+func (p *Plugin) ID() string {
+	return p.plugin.ID
+}
+
+func (p *Plugin) Name() string {
+	return p.plugin.Name
+}
+
+func (p *Plugin) Init(api *gin.RouterGroup) error {
+	return nil
+}
+
+func (p *Plugin) Close() {
+	close(p.closeChan)
+	<-p.closedChan
+}
+
+// Health reports whether the plugin process is running and connected.
+func (p *Plugin) Health() *registry.Health {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	h := *p.health
+	return &h
+}
+
+func (p *Plugin) Groups() []*registry.Group {
+	if c, err := p.getConn(); err == nil {
+		groups := []*registry.Group{}
+		if err := callWithTimeout(c, methodGroups, &groups); err == nil {
+			p.mutex.Lock()
+			p.groups = groups
+			p.mutex.Unlock()
+		} else {
+			p.logger.Error().Msg(err.Error())
+		}
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]*registry.Group{}, p.groups...)
+}
+
+func (p *Plugin) Lamps() []*registry.Lamp {
+	if c, err := p.getConn(); err == nil {
+		lamps := []*registry.Lamp{}
+		if err := callWithTimeout(c, methodLamps, &lamps); err == nil {
+			p.mutex.Lock()
+			p.lamps = lamps
+			p.mutex.Unlock()
+		} else {
+			p.logger.Error().Msg(err.Error())
+		}
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]*registry.Lamp{}, p.lamps...)
+}
+
+func (p *Plugin) Apply(ctx context.Context, changes []*registry.Change) []*registry.Result {
+	c, err := p.getConn()
+	if err != nil {
+		return registry.NewResults(changes, err)
+	}
+	results := []*registry.Result{}
+	if err := c.call(
+		ctx,
+		methodApply,
+		&applyParams{Changes: changes},
+		&results,
+	); err != nil {
+		return registry.NewResults(changes, err)
+	}
+
+	// Plugins that do not report results are assumed to have applied every
+	// change successfully
+	if len(results) == 0 {
+		return registry.NewResults(changes, nil)
+	}
+	return results
+}
+
+func (p *Plugin) ApplyToAll(ctx context.Context, change *registry.Change) error {
+	c, err := p.getConn()
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, methodApplyToAll, &applyToAllParams{Change: change}, nil)
+}
@@ -0,0 +1,112 @@
+package plugins
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lampctl/lampctl/registry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidPlugin = errors.New("invalid plugin specified")
+	ErrDuplicateID   = errors.New("a provider with that ID already exists")
+	ErrMissingFields = errors.New("either command or socket is required")
+)
+
+// Manager loads plugins from the manifests in the plugin directory and
+// registers each of them as a provider that can be enabled and disabled like
+// the built-in ones. Plugins cannot be added at runtime since doing so would
+// allow any API client to run arbitrary commands.
+type Manager struct {
+	logger   zerolog.Logger
+	registry *registry.Registry
+	plugins  map[string]*Manifest
+}
+
+// New creates a new Manager instance and defines a plugin for each manifest
+// in the plugin directory.
+func New(cfg *Config) (*Manager, error) {
+	m := &Manager{
+		logger:   log.With().Str("package", "plugins").Logger(),
+		registry: cfg.Registry,
+		plugins:  make(map[string]*Manifest),
+	}
+	if cfg.Dir == "" {
+		return m, nil
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != manifestExt {
+			continue
+		}
+		v, err := loadManifest(cfg.Dir, e.Name())
+		if err == nil {
+			err = m.define(v)
+		}
+		if err != nil {
+			m.logger.Error().Str("plugin", e.Name()).Msg(err.Error())
+		}
+	}
+	return m, nil
+}
+
+// exists determines whether a provider with the specified ID is already
+// registered or defined.
+func (m *Manager) exists(id string) bool {
+	if _, err := m.registry.GetProvider(id); err == nil {
+		return true
+	}
+	for _, d := range m.registry.Definitions() {
+		if d.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) define(v *Manifest) error {
+	if m.exists(v.ID) {
+		return ErrDuplicateID
+	}
+	if err := m.registry.Define(&registry.Definition{
+		ID:   v.ID,
+		Name: v.Name,
+		Factory: func() (registry.Provider, error) {
+			return newPlugin(m.logger, m.registry, v), nil
+		},
+	}); err != nil {
+		return err
+	}
+	m.plugins[v.ID] = v
+	return nil
+}
+
+// Plugins returns the manifests of all plugins that were loaded.
+func (m *Manager) Plugins() []*Manifest {
+	plugins := []*Manifest{}
+	for _, v := range m.plugins {
+		plugins = append(plugins, v)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].ID < plugins[j].ID
+	})
+	return plugins
+}
+
+// SetEnabled enables or disables the plugin.
+func (m *Manager) SetEnabled(id string, enabled bool) error {
+	if _, ok := m.plugins[id]; !ok {
+		return ErrInvalidPlugin
+	}
+	if enabled {
+		return m.registry.Enable(id)
+	}
+	return m.registry.Disable(id)
+}
@@ -0,0 +1,152 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const jsonRPCVersion = "2.0"
+
+var (
+	errConnClosed = errors.New("connection to plugin closed")
+)
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+// rpcMessage is used for requests, responses, and notifications, which are
+// distinguished by the presence of the ID and method.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcConn implements a JSON-RPC 2.0 client over a stream of newline-delimited
+// JSON messages. Notifications sent by the plugin are passed to the handler.
+type rpcConn struct {
+	mutex      sync.Mutex
+	writer     io.WriteCloser
+	encoder    *json.Encoder
+	nextID     int64
+	pending    map[int64]chan *rpcMessage
+	handler    func(method string, params json.RawMessage)
+	closed     bool
+	closedChan chan any
+}
+
+func newRPCConn(
+	r io.Reader,
+	w io.WriteCloser,
+	handler func(string, json.RawMessage),
+) *rpcConn {
+	c := &rpcConn{
+		writer:     w,
+		encoder:    json.NewEncoder(w),
+		pending:    make(map[int64]chan *rpcMessage),
+		handler:    handler,
+		closedChan: make(chan any),
+	}
+	go c.readLoop(r)
+	return c
+}
+
+func (c *rpcConn) readLoop(r io.Reader) {
+	defer close(c.closedChan)
+	defer func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.closed = true
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+	}()
+	decoder := json.NewDecoder(r)
+	for {
+		m := &rpcMessage{}
+		if err := decoder.Decode(m); err != nil {
+			return
+		}
+		if m.ID == nil {
+			if m.Method != "" {
+				c.handler(m.Method, m.Params)
+			}
+			continue
+		}
+		c.mutex.Lock()
+		ch, ok := c.pending[*m.ID]
+		delete(c.pending, *m.ID)
+		c.mutex.Unlock()
+		if ok {
+			ch <- m
+		}
+	}
+}
+
+// call sends a request and waits for the response (or for the context to be
+// done), decoding the result into the provided value.
+func (c *rpcConn) call(
+	ctx context.Context,
+	method string,
+	params, result interface{},
+) error {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	ch := make(chan *rpcMessage, 1)
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return errConnClosed
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	err = c.encoder.Encode(&rpcMessage{
+		JSONRPC: jsonRPCVersion,
+		ID:      &id,
+		Method:  method,
+		Params:  p,
+	})
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case m, ok := <-ch:
+		if !ok {
+			return errConnClosed
+		}
+		if m.Error != nil {
+			return m.Error
+		}
+		if result != nil && len(m.Result) > 0 {
+			return json.Unmarshal(m.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *rpcConn) close() {
+	c.writer.Close()
+}
@@ -0,0 +1,12 @@
+package presence
+
+import (
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+)
+
+// Config provides the configuration for the presence simulation.
+type Config struct {
+	DB       *db.Conn
+	Registry *registry.Registry
+}
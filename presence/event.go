@@ -0,0 +1,23 @@
+package presence
+
+const (
+	EventEnabled  = "enabled"
+	EventDisabled = "disabled"
+	EventPlanned  = "planned"
+)
+
+// Event indicates that the simulation was enabled or disabled or that the
+// plan for an evening was made.
+type Event struct {
+	Type   string  `json:"type"`
+	Status *Status `json:"status"`
+}
+
+// Watch registers a function that is invoked for every event.
+func (p *Presence) Watch(fn func(e *Event)) {
+	p.watchers.Add(fn)
+}
+
+func (p *Presence) notify(e *Event) {
+	p.watchers.Notify(e)
+}
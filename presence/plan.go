@@ -0,0 +1,276 @@
+package presence
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lampctl/lampctl/registry"
+)
+
+const (
+	// minPeriod is the shortest time a lamp is turned on for; shorter
+	// periods (usually the result of jitter) are dropped
+	minPeriod = time.Minute
+
+	// historyLimit is the number of changes to each lamp during an evening
+	// that are learned from, which is the most that the registry returns
+	historyLimit = 1000
+)
+
+// learnedSources are the sources of changes made by people at home, which
+// are the only changes that are learned from.
+var learnedSources = []string{
+	registry.SourceREST,
+	registry.SourceWebsocket,
+	registry.SourceExternal,
+}
+
+// Step is a planned change to a lamp.
+type Step struct {
+	registry.Target
+	At         time.Time `json:"at"`
+	State      bool      `json:"state"`
+	Brightness float64   `json:"brightness,omitempty"`
+}
+
+// period is a span of time, relative to the start of a window, during which
+// a lamp is on.
+type period struct {
+	target     registry.Target
+	on         time.Duration
+	off        time.Duration
+	brightness float64
+}
+
+// targets returns every lamp matching the selector or all lamps if it is
+// nil.
+func (p *Presence) targets(s *registry.Selector) []*registry.Target {
+	if s != nil {
+		return p.registry.Select(s, "")
+	}
+	targets := []*registry.Target{}
+	for _, v := range p.registry.Providers() {
+		for _, l := range p.registry.Lamps(v) {
+			targets = append(targets, &registry.Target{
+				ProviderID: v.ID(),
+				GroupID:    l.GroupID,
+				LampID:     l.ID,
+			})
+		}
+	}
+	return targets
+}
+
+// learn finds when each lamp was on during the windows of the evenings
+// before w. Only evenings with at least one lamp on are returned. The
+// history is queried for each evening separately so that busy evenings do
+// not crowd out the others.
+func (p *Presence) learn(s *Settings, loc *time.Location, w *window) ([][]*period, error) {
+	var (
+		y, m, d = w.start.In(loc).Date()
+		targets = p.targets(s.Selector)
+		active  = [][]*period{}
+	)
+	for i := 1; i <= s.Days; i++ {
+		day := s.on(y, m, d-i, loc)
+		periods := []*period{}
+		for _, t := range targets {
+			v, err := p.lampPeriods(t, day)
+			if err != nil {
+				return nil, err
+			}
+			periods = append(periods, v...)
+		}
+		if len(periods) > 0 {
+			active = append(active, periods)
+		}
+	}
+	if len(active) == 0 {
+		return nil, ErrNoPattern
+	}
+	return active, nil
+}
+
+// lampPeriods returns the periods during the window in which the lamp was
+// on.
+func (p *Presence) lampPeriods(t *registry.Target, w *window) ([]*period, error) {
+	q := &registry.HistoryQuery{
+		ProviderID: t.ProviderID,
+		GroupID:    t.GroupID,
+		LampID:     t.LampID,
+		Sources:    learnedSources,
+
+		// The last change from the day before is included so that a lamp
+		// that was already on when the window started is found
+		Since: w.start.Add(-24 * time.Hour),
+		Until: w.start,
+		Limit: 1,
+	}
+	before, err := p.registry.History(q)
+	if err != nil {
+		return nil, err
+	}
+	q.Since, q.Until, q.Limit = w.start, w.end, historyLimit
+	entries, err := p.registry.History(q)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		periods    = []*period{}
+		lit        = false
+		on         time.Time
+		brightness float64
+	)
+	add := func(off time.Time) {
+		if on.Before(w.start) {
+			on = w.start
+		}
+		if off.Sub(on) < minPeriod {
+			return
+		}
+		periods = append(periods, &period{
+			target:     *t,
+			on:         on.Sub(w.start),
+			off:        off.Sub(w.start),
+			brightness: brightness,
+		})
+	}
+
+	// Entries are returned newest first
+	entries = append(entries, before...)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		switch {
+		case e.Detail != "":
+		case e.State && !lit:
+			lit, on, brightness = true, e.Time, e.Brightness
+		case e.State:
+			brightness = e.Brightness
+		case lit:
+			lit = false
+			add(e.Time)
+		}
+	}
+	if lit {
+		add(w.end)
+	}
+	return periods, nil
+}
+
+// template returns the periods in the template for every lamp they apply
+// to.
+func (p *Presence) template(s *Settings) []*period {
+	periods := []*period{}
+	for _, v := range s.Template {
+		targets := v.Targets
+		if v.Selector != nil {
+			targets = append(
+				append([]*registry.Target{}, targets...),
+				p.registry.Select(v.Selector, "")...,
+			)
+		}
+		for _, t := range targets {
+			periods = append(periods, &period{
+				target:     *t,
+				on:         time.Duration(s.offset(v.On)) * time.Minute,
+				off:        time.Duration(s.offset(v.Off)) * time.Minute,
+				brightness: v.Brightness,
+			})
+		}
+	}
+	return periods
+}
+
+// jitter moves the time by a random amount within the jitter, keeping it
+// inside a window of the specified length.
+func (p *Presence) jitter(s *Settings, v, length time.Duration) time.Duration {
+	if s.Jitter > 0 {
+		j := time.Duration(s.Jitter) * time.Minute
+		v += time.Duration(p.rand.Int63n(int64(2*j)+1)) - j
+	}
+	if v < 0 {
+		v = 0
+	}
+	if v > length {
+		v = length
+	}
+	return v
+}
+
+// makePlan returns the steps for the window that now falls in (or the next
+// one). Steps for periods already in progress are moved to now and those
+// that have ended are dropped. Overlapping periods for the same lamp are
+// merged. The history may be queried, so planMutex must be held but not
+// mutex.
+func (p *Presence) makePlan(s *Settings, loc *time.Location, now time.Time) (*window, []*Step, error) {
+	w := s.current(now, loc)
+	var periods []*period
+	switch s.Mode {
+	case ModeLearn:
+		days, err := p.learn(s, loc, w)
+		if err != nil {
+			return nil, nil, err
+		}
+		periods = days[p.rand.Intn(len(days))]
+	case ModeTemplate:
+		periods = p.template(s)
+	}
+	var (
+		length = w.end.Sub(w.start)
+		byLamp = map[registry.Target][]*period{}
+		lamps  = []registry.Target{}
+	)
+	for _, v := range periods {
+		j := &period{
+			target:     v.target,
+			on:         p.jitter(s, v.on, length),
+			off:        p.jitter(s, v.off, length),
+			brightness: v.brightness,
+		}
+		if j.off-j.on < minPeriod {
+			continue
+		}
+		if _, ok := byLamp[v.target]; !ok {
+			lamps = append(lamps, v.target)
+		}
+		byLamp[v.target] = append(byLamp[v.target], j)
+	}
+	steps := []*Step{}
+	for _, t := range lamps {
+		v := byLamp[t]
+		sort.Slice(v, func(i, j int) bool {
+			return v[i].on < v[j].on
+		})
+		merged := []*period{v[0]}
+		for _, c := range v[1:] {
+			last := merged[len(merged)-1]
+			if c.on <= last.off {
+				if c.off > last.off {
+					last.off = c.off
+				}
+				continue
+			}
+			merged = append(merged, c)
+		}
+		for _, c := range merged {
+			var (
+				on  = w.start.Add(c.on)
+				off = w.start.Add(c.off)
+			)
+			if !off.After(now) {
+				continue
+			}
+			if on.Before(now) {
+				on = now
+			}
+			steps = append(steps,
+				&Step{Target: t, At: on, State: true, Brightness: c.brightness},
+				&Step{Target: t, At: off},
+			)
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].At.Before(steps[j].At)
+	})
+	return w, steps, nil
+}
@@ -0,0 +1,455 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	keyEnabled  = "presence.enabled"
+	keySettings = "presence.settings"
+	keyLit      = "presence.lit"
+)
+
+var ErrNoPattern = errors.New("no activity in the history to learn from")
+
+// Status describes the simulation. Start and End are the window that the
+// plan covers, which is only set while the simulation is enabled.
+type Status struct {
+	Enabled  bool       `json:"enabled"`
+	Settings *Settings  `json:"settings"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Plan     []*Step    `json:"plan"`
+}
+
+// Presence makes the house look lived in while nobody is home by switching
+// lamps every evening the way people do. Changes to the simulation hold
+// planMutex throughout so that the history can be queried for a new plan
+// without holding mutex.
+type Presence struct {
+	planMutex  sync.Mutex
+	mutex      sync.Mutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	registry   *registry.Registry
+	rand       *rand.Rand
+	enabled    bool
+	settings   *Settings
+	location   *time.Location
+	window     *window
+	plan       []*Step
+	lit        map[registry.Target]bool
+	watchers   util.Watchers[*Event]
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func (p *Presence) run() {
+	defer close(p.closedChan)
+	for {
+		t := time.NewTimer(p.step())
+		select {
+		case <-t.C:
+		case <-p.wakeChan:
+			t.Stop()
+		case <-p.closeChan:
+			t.Stop()
+			return
+		}
+	}
+}
+
+func (p *Presence) wake() {
+	select {
+	case p.wakeChan <- nil:
+	default:
+	}
+}
+
+// step applies the steps that are due, planning the next evening once the
+// current one ends, and returns the amount of time to wait before the next
+// step.
+func (p *Presence) step() time.Duration {
+	p.planMutex.Lock()
+	now := time.Now()
+	p.mutex.Lock()
+	if !p.enabled {
+		p.mutex.Unlock()
+		p.planMutex.Unlock()
+		return util.MaxSleep
+	}
+	var (
+		due      = p.take(now, nil)
+		planned  = p.window == nil || !now.Before(p.window.end)
+		settings = p.settings
+		location = p.location
+	)
+	p.mutex.Unlock()
+	var (
+		w     *window
+		steps []*Step
+		err   error
+	)
+	if planned {
+		w, steps, err = p.makePlan(settings, location, now)
+		if err != nil {
+			p.logger.Warn().Msg(err.Error())
+			w, steps = settings.current(now, location), []*Step{}
+		}
+	}
+	p.mutex.Lock()
+	if planned {
+		p.install(w, steps, now)
+		due = p.take(now, due)
+	}
+	next := now.Add(util.MaxSleep)
+	if len(p.plan) > 0 && p.plan[0].At.Before(next) {
+		next = p.plan[0].At
+	}
+	if p.window.end.Before(next) {
+		next = p.window.end
+	}
+	status, err := p.status()
+	p.mutex.Unlock()
+	p.planMutex.Unlock()
+	if err != nil {
+		p.logger.Error().Msg(err.Error())
+	} else if planned {
+		p.logger.Info().Msgf("planned %d step(s) until %s", len(status.Plan), status.End)
+		p.notify(&Event{
+			Type:   EventPlanned,
+			Status: status,
+		})
+	}
+	p.apply(due)
+	return next.Sub(now)
+}
+
+// take removes the steps that are due from the plan, keeping only the
+// latest for each lamp, and records which lamps were turned on. The mutex
+// must be held.
+func (p *Presence) take(now time.Time, due map[registry.Target]*Step) map[registry.Target]*Step {
+	if due == nil {
+		due = map[registry.Target]*Step{}
+	}
+	i := 0
+	for ; i < len(p.plan) && !p.plan[i].At.After(now); i++ {
+		s := p.plan[i]
+		due[s.Target] = s
+		if s.State {
+			p.lit[s.Target] = true
+		} else {
+			delete(p.lit, s.Target)
+		}
+	}
+	if i > 0 {
+		p.plan = p.plan[i:]
+		p.saveLit()
+	}
+	return due
+}
+
+// install replaces the plan. Lamps that were turned on by the previous plan
+// and are not on in the new one are turned off. The mutex must be held.
+func (p *Presence) install(w *window, steps []*Step, now time.Time) {
+	on := map[registry.Target]bool{}
+	for _, s := range steps {
+		if s.State && !s.At.After(now) {
+			on[s.Target] = true
+		}
+	}
+	off := []*Step{}
+	for t := range p.lit {
+		if !on[t] {
+			off = append(off, &Step{Target: t, At: now})
+		}
+	}
+	p.window = w
+	p.plan = append(off, steps...)
+	sort.SliceStable(p.plan, func(i, j int) bool {
+		return p.plan[i].At.Before(p.plan[j].At)
+	})
+}
+
+// saveLit stores the lamps that are on so that they can be turned off after
+// a restart. The mutex must be held.
+func (p *Presence) saveLit() {
+	targets := []registry.Target{}
+	for t := range p.lit {
+		targets = append(targets, t)
+	}
+	b, err := json.Marshal(targets)
+	if err == nil {
+		err = p.db.SetStringSetting(keyLit, string(b))
+	}
+	if err != nil {
+		p.logger.Error().Msg(err.Error())
+	}
+}
+
+// apply applies the steps to their lamps.
+func (p *Presence) apply(steps map[registry.Target]*Step) {
+	if len(steps) == 0 {
+		return
+	}
+	changes := []*registry.Change{}
+	for t, s := range steps {
+		changes = append(changes, &registry.Change{
+			ProviderID: t.ProviderID,
+			GroupID:    t.GroupID,
+			LampID:     t.LampID,
+			State:      s.State,
+			Brightness: s.Brightness,
+		})
+	}
+	ctx := registry.WithSource(context.Background(), registry.SourcePresence, "")
+	if err := registry.Err(p.registry.ApplyChanges(ctx, changes)); err != nil {
+		p.logger.Error().Msg(err.Error())
+	}
+}
+
+// status returns the current status. The mutex must be held.
+func (p *Presence) status() (*Status, error) {
+	settings, err := p.settings.clone()
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{
+		Enabled:  p.enabled,
+		Settings: settings,
+		Plan:     []*Step{},
+	}
+	if p.enabled && p.window != nil {
+		start, end := p.window.start, p.window.end
+		s.Start, s.End = &start, &end
+	}
+	for _, v := range p.plan {
+		c := *v
+		s.Plan = append(s.Plan, &c)
+	}
+	return s, nil
+}
+
+// New creates a new presence simulation, resuming it if it was enabled.
+func New(cfg *Config) (*Presence, error) {
+	p := &Presence{
+		logger:     log.With().Str("package", "presence").Logger(),
+		db:         cfg.DB,
+		registry:   cfg.Registry,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		settings:   defaultSettings(),
+		lit:        make(map[registry.Target]bool),
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	v, err := p.db.GetStringSetting(keySettings, "")
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		if err := json.Unmarshal([]byte(v), p.settings); err != nil {
+			return nil, err
+		}
+	}
+	loc, err := p.settings.validate()
+	if err != nil {
+		return nil, err
+	}
+	p.location = loc
+	enabled, err := p.db.GetBoolSetting(keyEnabled, false)
+	if err != nil {
+		return nil, err
+	}
+	p.enabled = enabled
+	v, err = p.db.GetStringSetting(keyLit, "")
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		targets := []registry.Target{}
+		if err := json.Unmarshal([]byte(v), &targets); err != nil {
+			return nil, err
+		}
+		for _, t := range targets {
+			p.lit[t] = true
+		}
+	}
+	go p.run()
+	return p, nil
+}
+
+// Status returns whether the simulation is enabled, its settings, and the
+// remaining steps planned for the evening.
+func (p *Presence) Status() (*Status, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.status()
+}
+
+// Settings returns a copy of the settings.
+func (p *Presence) Settings() (*Settings, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.settings.clone()
+}
+
+// SetSettings stores the settings. If the simulation is enabled, the
+// evening is planned again with them.
+func (p *Presence) SetSettings(s *Settings) error {
+	loc, err := s.validate()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	settings, err := s.clone()
+	if err != nil {
+		return err
+	}
+	p.planMutex.Lock()
+	defer p.planMutex.Unlock()
+	now := time.Now()
+	p.mutex.Lock()
+	enabled := p.enabled
+	p.mutex.Unlock()
+	var (
+		w     *window
+		steps []*Step
+	)
+	if enabled {
+		w, steps, err = p.makePlan(settings, loc, now)
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.db.SetStringSetting(keySettings, string(b)); err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	p.settings = settings
+	p.location = loc
+	if enabled {
+		p.install(w, steps, now)
+	}
+	status, err := p.status()
+	p.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	p.wake()
+	if enabled {
+		p.notify(&Event{
+			Type:   EventPlanned,
+			Status: status,
+		})
+	}
+	return nil
+}
+
+// Enable starts the simulation, which begins immediately if the current
+// time is within the window. In learn mode, ErrNoPattern is returned if
+// there is nothing in the history to learn from.
+func (p *Presence) Enable() error {
+	p.planMutex.Lock()
+	defer p.planMutex.Unlock()
+	now := time.Now()
+	p.mutex.Lock()
+	var (
+		enabled  = p.enabled
+		settings = p.settings
+		location = p.location
+	)
+	p.mutex.Unlock()
+	if enabled {
+		return nil
+	}
+	w, steps, err := p.makePlan(settings, location, now)
+	if err != nil {
+		return err
+	}
+	if err := p.db.SetBoolSetting(keyEnabled, true); err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	p.enabled = true
+	p.install(w, steps, now)
+	status, err := p.status()
+	p.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	p.logger.Info().Msg("simulation enabled")
+	p.wake()
+	p.notify(&Event{
+		Type:   EventEnabled,
+		Status: status,
+	})
+	return nil
+}
+
+// Disable stops the simulation and turns off the lamps that it turned on.
+func (p *Presence) Disable() error {
+	p.planMutex.Lock()
+	defer p.planMutex.Unlock()
+	p.mutex.Lock()
+	if !p.enabled {
+		p.mutex.Unlock()
+		return nil
+	}
+	if err := p.db.SetBoolSetting(keyEnabled, false); err != nil {
+		p.mutex.Unlock()
+		return err
+	}
+	off := map[registry.Target]*Step{}
+	for t := range p.lit {
+		off[t] = &Step{Target: t}
+	}
+	p.enabled = false
+	p.window = nil
+	p.plan = nil
+	p.lit = make(map[registry.Target]bool)
+	p.saveLit()
+	status, err := p.status()
+	p.mutex.Unlock()
+	p.logger.Info().Msg("simulation disabled")
+	p.apply(off)
+	if err != nil {
+		return err
+	}
+	p.notify(&Event{
+		Type:   EventDisabled,
+		Status: status,
+	})
+	return nil
+}
+
+// SetEnabled enables or disables the simulation.
+func (p *Presence) SetEnabled(enabled bool) error {
+	if enabled {
+		return p.Enable()
+	}
+	return p.Disable()
+}
+
+// Close stops the simulation, leaving lamps as they are so that it can
+// resume when the application starts again.
+func (p *Presence) Close() {
+	close(p.closeChan)
+	<-p.closedChan
+}
@@ -0,0 +1,187 @@
+package presence
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/util"
+)
+
+const (
+	ModeLearn    = "learn"
+	ModeTemplate = "template"
+
+	defaultStart  = "17:00"
+	defaultEnd    = "23:30"
+	defaultJitter = 20
+	defaultDays   = 14
+
+	maxJitter = 120
+	maxDays   = 90
+)
+
+// Period turns lamps on at On and off at Off, both in the form "15:04".
+// Lamps are specified by targets, a selector, or both. If Brightness is
+// zero, lamps are turned on at full brightness.
+type Period struct {
+	Targets    []*registry.Target `json:"targets,omitempty"`
+	Selector   *registry.Selector `json:"selector,omitempty"`
+	On         string             `json:"on"`
+	Off        string             `json:"off"`
+	Brightness float64            `json:"brightness,omitempty"`
+}
+
+// Settings control the simulation, which runs every evening between Start
+// and End (in the form "15:04") in the timezone, which defaults to the local
+// timezone. The window may span midnight.
+//
+// In learn mode, one of the previous Days evenings is picked at random and
+// the lamps matching Selector (or all lamps if it is empty) are switched as
+// they were then by people at home. In template mode, the periods in
+// Template are used instead. Either way, the time of each change is moved
+// by up to Jitter minutes in either direction.
+type Settings struct {
+	Mode     string             `json:"mode"`
+	Selector *registry.Selector `json:"selector,omitempty"`
+	Start    string             `json:"start"`
+	End      string             `json:"end"`
+	Timezone string             `json:"timezone,omitempty"`
+	Jitter   int                `json:"jitter"`
+	Days     int                `json:"days"`
+	Template []*Period          `json:"template"`
+}
+
+func defaultSettings() *Settings {
+	return &Settings{
+		Mode:     ModeLearn,
+		Start:    defaultStart,
+		End:      defaultEnd,
+		Jitter:   defaultJitter,
+		Days:     defaultDays,
+		Template: []*Period{},
+	}
+}
+
+// clone returns a deep copy of the settings.
+func (s *Settings) clone() (*Settings, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	v := &Settings{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// window describes an evening in which the simulation runs.
+type window struct {
+	start time.Time
+	end   time.Time
+}
+
+// length returns the duration of the window in minutes, which may span
+// midnight.
+func (s *Settings) length() int {
+	start, _ := util.ParseClock(s.Start)
+	end, _ := util.ParseClock(s.End)
+	if end <= start {
+		end += 24 * 60
+	}
+	return end - start
+}
+
+// on returns the window that starts on the day in the location.
+func (s *Settings) on(year int, month time.Month, day int, loc *time.Location) *window {
+	start, _ := util.ParseClock(s.Start)
+	v := time.Date(year, month, day, start/60, start%60, 0, 0, loc)
+	return &window{
+		start: v,
+		end:   v.Add(time.Duration(s.length()) * time.Minute),
+	}
+}
+
+// current returns the window that t falls in or, if there is none, the next
+// one.
+func (s *Settings) current(t time.Time, loc *time.Location) *window {
+	y, m, d := t.In(loc).Date()
+	for i := -1; ; i++ {
+		if w := s.on(y, m, d+i, loc); w.end.After(t) {
+			return w
+		}
+	}
+}
+
+// offset returns the number of minutes that the time in the form "15:04"
+// is after the start of the window.
+func (s *Settings) offset(v string) int {
+	start, _ := util.ParseClock(s.Start)
+	t, _ := util.ParseClock(v)
+	return ((t-start)%(24*60) + 24*60) % (24 * 60)
+}
+
+func validatePeriod(s *Settings, v *Period) error {
+	if v == nil {
+		return registry.Rejectf("periods cannot be null")
+	}
+	if len(v.Targets) == 0 && v.Selector == nil {
+		return registry.Rejectf("each period requires targets or a selector")
+	}
+	for _, t := range []string{v.On, v.Off} {
+		if _, err := util.ParseClock(t); err != nil {
+			return err
+		}
+	}
+	on, off := s.offset(v.On), s.offset(v.Off)
+	if on >= off || off > s.length() {
+		return registry.Rejectf("period %s-%s must fall within %s-%s", v.On, v.Off, s.Start, s.End)
+	}
+	if v.Brightness < 0 || v.Brightness > 1 {
+		return registry.Rejectf("brightness must be between 0 and 1")
+	}
+	return nil
+}
+
+// validate checks the settings and returns the timezone they use.
+func (s *Settings) validate() (*time.Location, error) {
+	switch s.Mode {
+	case ModeLearn, ModeTemplate:
+	default:
+		return nil, registry.Rejectf("invalid mode %q", s.Mode)
+	}
+	start, err := util.ParseClock(s.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := util.ParseClock(s.End)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, registry.Rejectf("start and end cannot be the same")
+	}
+	loc, err := util.LoadTimezone(s.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	if s.Jitter < 0 || s.Jitter > maxJitter {
+		return nil, registry.Rejectf("jitter must be between 0 and %d minutes", maxJitter)
+	}
+	if s.Days < 1 || s.Days > maxDays {
+		return nil, registry.Rejectf("days must be between 1 and %d", maxDays)
+	}
+	if s.Template == nil {
+		s.Template = []*Period{}
+	}
+	if s.Mode == ModeTemplate && len(s.Template) == 0 {
+		return nil, registry.Rejectf("template requires at least one period")
+	}
+	for _, v := range s.Template {
+		if err := validatePeriod(s, v); err != nil {
+			return nil, err
+		}
+	}
+	return loc, nil
+}
@@ -0,0 +1,272 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+const (
+	minKelvin = 1000
+	maxKelvin = 40000
+)
+
+// Color represents a color that can be specified in a number of different
+// formats. Providers convert it to whatever representation their hardware
+// expects using the helper methods below.
+type Color struct {
+	colorful.Color
+
+	// Kelvin is set when the color was specified as a color temperature,
+	// allowing providers with native support to use it directly.
+	Kelvin int
+}
+
+type colorJSON struct {
+	R      *float64 `json:"r"`
+	G      *float64 `json:"g"`
+	B      *float64 `json:"b"`
+	H      *float64 `json:"h"`
+	S      *float64 `json:"s"`
+	V      *float64 `json:"v"`
+	X      *float64 `json:"x"`
+	Y      *float64 `json:"y"`
+	Kelvin *int     `json:"kelvin"`
+}
+
+// NewRGBColor creates a color from 8-bit red, green, and blue components.
+func NewRGBColor(r, g, b uint8) *Color {
+	return &Color{
+		Color: colorful.Color{
+			R: float64(r) / 255,
+			G: float64(g) / 255,
+			B: float64(b) / 255,
+		},
+	}
+}
+
+// NewHSVColor creates a color from a hue in degrees and saturation and value
+// between 0 and 1.
+func NewHSVColor(h, s, v float64) *Color {
+	return &Color{
+		Color: colorful.Hsv(math.Mod(h, 360), clamp01(s), clamp01(v)).Clamped(),
+	}
+}
+
+// NewXYColor creates a color from CIE 1931 xy chromaticity coordinates. The
+// resulting color is scaled to full brightness.
+func NewXYColor(x, y float64) *Color {
+	c := colorful.Xyy(x, y, 1.0)
+	if m := math.Max(c.R, math.Max(c.G, c.B)); m > 0 {
+		c.R /= m
+		c.G /= m
+		c.B /= m
+	}
+	return &Color{
+		Color: c.Clamped(),
+	}
+}
+
+// NewKelvinColor creates a color from a color temperature in Kelvin.
+func NewKelvinColor(kelvin int) *Color {
+	if kelvin < minKelvin {
+		kelvin = minKelvin
+	}
+	if kelvin > maxKelvin {
+		kelvin = maxKelvin
+	}
+
+	// This uses the approximation by Tanner Helland, which is accurate enough
+	// for lighting purposes
+	var (
+		t       = float64(kelvin) / 100
+		r, g, b float64
+	)
+	if t <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+	switch {
+	case t >= 66:
+		b = 255
+	case t <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+	return &Color{
+		Color: colorful.Color{
+			R: clamp01(r / 255),
+			G: clamp01(g / 255),
+			B: clamp01(b / 255),
+		},
+		Kelvin: kelvin,
+	}
+}
+
+// ParseColor parses a color in one of the following formats:
+//
+//   - hex: "#ff8800", "ff8800", or "#f80"
+//   - RGB: "rgb(255, 136, 0)"
+//   - HSV: "hsv(32, 1, 1)" (saturation and value may also be percentages)
+//   - CIE xy: "xy(0.5, 0.4)"
+//   - color temperature: "2700K"
+//   - CSS color names: "orange"
+func ParseColor(s string) (*Color, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if v == "" {
+		return nil, ErrInvalidColor
+	}
+	if c, ok := cssColors[v]; ok {
+		return NewRGBColor(uint8(c>>16), uint8(c>>8), uint8(c)), nil
+	}
+	if strings.HasSuffix(v, "k") {
+		k, err := strconv.Atoi(strings.TrimSuffix(v, "k"))
+		if err != nil {
+			return nil, invalidColor(s)
+		}
+		return NewKelvinColor(k), nil
+	}
+	if i := strings.Index(v, "("); i != -1 && strings.HasSuffix(v, ")") {
+		args, err := parseColorArgs(v[i+1 : len(v)-1])
+		if err != nil {
+			return nil, invalidColor(s)
+		}
+		switch v[:i] {
+		case "rgb":
+			if len(args) == 3 {
+				return &Color{
+					Color: colorful.Color{
+						R: clamp01(args[0] / 255),
+						G: clamp01(args[1] / 255),
+						B: clamp01(args[2] / 255),
+					},
+				}, nil
+			}
+		case "hsv":
+			if len(args) == 3 {
+				return NewHSVColor(args[0], args[1], args[2]), nil
+			}
+		case "xy":
+			if len(args) == 2 {
+				return NewXYColor(args[0], args[1]), nil
+			}
+		}
+		return nil, invalidColor(s)
+	}
+	if !strings.HasPrefix(v, "#") {
+		v = "#" + v
+	}
+	if len(v) == 4 {
+		v = string([]byte{'#', v[1], v[1], v[2], v[2], v[3], v[3]})
+	}
+	c, err := colorful.Hex(v)
+	if err != nil {
+		return nil, invalidColor(s)
+	}
+	return &Color{Color: c}, nil
+}
+
+func parseColorArgs(s string) ([]float64, error) {
+	args := []float64{}
+	for _, a := range strings.Split(s, ",") {
+		var (
+			a       = strings.TrimSpace(a)
+			divisor = 1.0
+		)
+		if strings.HasSuffix(a, "%") {
+			a = strings.TrimSuffix(a, "%")
+			divisor = 100
+		}
+		v, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v/divisor)
+	}
+	return args, nil
+}
+
+func invalidColor(s string) error {
+	return fmt.Errorf("%w: %q", ErrInvalidColor, s)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// RGB returns the 8-bit red, green, and blue components of the color.
+func (c *Color) RGB() (r, g, b uint8) {
+	return c.Color.Clamped().RGB255()
+}
+
+// XY returns the CIE 1931 xy chromaticity coordinates of the color.
+func (c *Color) XY() (x, y float64) {
+	x, y, _ = c.Color.Clamped().Xyy()
+	return
+}
+
+// Mirek returns the color temperature in mirek (reciprocal megakelvin) or
+// zero if the color was not specified as a temperature.
+func (c *Color) Mirek() int {
+	if c.Kelvin == 0 {
+		return 0
+	}
+	return int(math.Round(1000000 / float64(c.Kelvin)))
+}
+
+// String returns the color in a form that can be parsed by ParseColor.
+func (c *Color) String() string {
+	if c.Kelvin != 0 {
+		return fmt.Sprintf("%dK", c.Kelvin)
+	}
+	return c.Color.Clamped().Hex()
+}
+
+// MarshalJSON encodes the color as a string.
+func (c *Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON decodes the color from either a string in one of the formats
+// accepted by ParseColor or an object with one of the following sets of
+// fields: r / g / b, h / s / v, x / y, or kelvin.
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := ParseColor(s)
+		if err != nil {
+			return err
+		}
+		*c = *v
+		return nil
+	}
+	v := &colorJSON{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidColor
+	}
+	switch {
+	case v.R != nil && v.G != nil && v.B != nil:
+		*c = Color{Color: colorful.Color{
+			R: clamp01(*v.R / 255),
+			G: clamp01(*v.G / 255),
+			B: clamp01(*v.B / 255),
+		}}
+	case v.H != nil && v.S != nil && v.V != nil:
+		*c = *NewHSVColor(*v.H, *v.S, *v.V)
+	case v.X != nil && v.Y != nil:
+		*c = *NewXYColor(*v.X, *v.Y)
+	case v.Kelvin != nil:
+		*c = *NewKelvinColor(*v.Kelvin)
+	default:
+		return ErrInvalidColor
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+)
+
+// Config provides the configuration for the registry.
+type Config struct {
+
+	// DB is used to store which providers are enabled, lamp tags and
+	// metadata, the last state of each lamp, enforced lamps, scenes, pending
+	// timers, maximum on-times, and the history of changes.
+	DB *db.Conn
+
+	// FrameRate determines how many times per second transitions are updated
+	// for providers that cannot fade on their own.
+	FrameRate int
+
+	// ReconcileInterval determines how often the state of each lamp is
+	// compared with the state it was last set to.
+	ReconcileInterval time.Duration
+
+	// HistoryRetention determines how long entries in the history are kept.
+	HistoryRetention time.Duration
+}
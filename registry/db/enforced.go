@@ -0,0 +1,9 @@
+package db
+
+// Enforced marks a lamp whose state is restored by the registry whenever it
+// is changed by something else, such as a wall switch.
+type Enforced struct {
+	ProviderID string `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string `gorm:"primaryKey" json:"group_id"`
+	LampID     string `gorm:"primaryKey" json:"lamp_id"`
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"time"
+)
+
+// History records a change applied to a lamp and what caused it. Detail is
+// used for entries that are not simple changes, such as starting an effect.
+type History struct {
+	ID         int64     `gorm:"primaryKey" json:"id"`
+	Time       time.Time `gorm:"not null;index" json:"time"`
+	ProviderID string    `gorm:"not null;index:idx_history_lamp" json:"provider_id"`
+	GroupID    string    `gorm:"not null;index:idx_history_lamp" json:"group_id"`
+	LampID     string    `gorm:"not null;index:idx_history_lamp" json:"lamp_id"`
+	State      bool      `gorm:"not null" json:"state"`
+	Brightness float64   `gorm:"not null" json:"brightness"`
+	Color      string    `gorm:"not null" json:"color,omitempty"`
+	Duration   int64     `gorm:"not null" json:"duration,omitempty"`
+	Detail     string    `gorm:"not null" json:"detail,omitempty"`
+	Source     string    `gorm:"not null;index" json:"source"`
+	SourceID   string    `gorm:"not null" json:"source_id,omitempty"`
+}
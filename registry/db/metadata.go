@@ -0,0 +1,15 @@
+package db
+
+// Metadata stores user-provided information about a lamp that overrides or
+// supplements what the provider reports. An empty Name leaves the name
+// reported by the provider unchanged.
+type Metadata struct {
+	ProviderID string  `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string  `gorm:"primaryKey" json:"group_id"`
+	LampID     string  `gorm:"primaryKey" json:"lamp_id"`
+	Name       string  `gorm:"not null" json:"name"`
+	Icon       string  `gorm:"not null" json:"icon"`
+	Location   string  `gorm:"not null" json:"location"`
+	Notes      string  `gorm:"not null" json:"notes"`
+	Wattage    float64 `gorm:"not null" json:"wattage"`
+}
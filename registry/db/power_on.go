@@ -0,0 +1,11 @@
+package db
+
+// PowerOn determines what a lamp does when its provider starts. SceneID is
+// only used when Behavior is "scene".
+type PowerOn struct {
+	ProviderID string `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string `gorm:"primaryKey" json:"group_id"`
+	LampID     string `gorm:"primaryKey" json:"lamp_id"`
+	Behavior   string `gorm:"not null" json:"behavior"`
+	SceneID    int64  `gorm:"not null" json:"scene_id"`
+}
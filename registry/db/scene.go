@@ -0,0 +1,9 @@
+package db
+
+// Scene stores a named set of changes. The changes are encoded as JSON since
+// they use types from the registry package.
+type Scene struct {
+	ID      int64  `gorm:"primaryKey"`
+	Name    string `gorm:"not null"`
+	Changes string `gorm:"not null"`
+}
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"time"
+)
+
+// State stores the last state applied to a lamp so that it can be restored
+// when the application starts. Color is stored in a format understood by
+// registry.ParseColor and is empty if no color was set.
+type State struct {
+	ProviderID string    `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string    `gorm:"primaryKey" json:"group_id"`
+	LampID     string    `gorm:"primaryKey" json:"lamp_id"`
+	State      bool      `gorm:"not null" json:"state"`
+	Brightness float64   `gorm:"not null" json:"brightness"`
+	Color      string    `gorm:"not null" json:"color"`
+	UpdatedAt  time.Time `gorm:"not null" json:"updated_at"`
+}
@@ -0,0 +1,10 @@
+package db
+
+// Tag associates a free-form label with a lamp so that it can be targeted
+// using a selector.
+type Tag struct {
+	ProviderID string `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string `gorm:"primaryKey" json:"group_id"`
+	LampID     string `gorm:"primaryKey" json:"lamp_id"`
+	Name       string `gorm:"primaryKey" json:"tag"`
+}
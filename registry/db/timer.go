@@ -0,0 +1,26 @@
+package db
+
+import (
+	"time"
+)
+
+// Timer changes a lamp once At is reached. Change is the JSON-encoded
+// change that is applied and Reason is either "revert" or "max_on".
+type Timer struct {
+	ProviderID string    `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string    `gorm:"primaryKey" json:"group_id"`
+	LampID     string    `gorm:"primaryKey" json:"lamp_id"`
+	Reason     string    `gorm:"not null" json:"reason"`
+	At         time.Time `gorm:"not null" json:"at"`
+	Change     string    `gorm:"not null" json:"-"`
+}
+
+// MaxOn is the longest a lamp may stay on before it is turned off. If
+// LampID is empty, it applies to every lamp in the group that does not have
+// its own. Duration is in milliseconds.
+type MaxOn struct {
+	ProviderID string `gorm:"primaryKey" json:"provider_id"`
+	GroupID    string `gorm:"primaryKey" json:"group_id"`
+	LampID     string `gorm:"primaryKey" json:"lamp_id"`
+	Duration   int64  `gorm:"not null" json:"duration"`
+}
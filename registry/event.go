@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	EventLamps    = "lamps"
+	EventProvider = "provider"
+	EventHealth   = "health"
+	EventInput    = "input"
+
+	// frameEventInterval is how long lamps events for frames are collected
+	// before being sent together
+	frameEventInterval = time.Second
+)
+
+// Event describes a change to the registry. Lamps events contain the current
+// state of lamps that were changed, either by applying changes or by
+// something outside of lampctl. Provider events are sent when a provider is
+// registered, becomes available, or is removed and contain all of its groups
+// and lamps. Health events are sent when the health status of a provider
+// changes. Input events are sent when a provider reports that an input, such
+// as a button, was used. Source is set for lamps events caused by changes.
+type Event struct {
+	Type       string   `json:"type"`
+	ProviderID string   `json:"provider_id"`
+	Name       string   `json:"name,omitempty"`
+	Removed    bool     `json:"removed,omitempty"`
+	Groups     []*Group `json:"groups,omitempty"`
+	Lamps      []*Lamp  `json:"lamps,omitempty"`
+	Health     *Health  `json:"health,omitempty"`
+	Input      *Input   `json:"input,omitempty"`
+	Source     *Source  `json:"source,omitempty"`
+}
+
+type subscription struct {
+	fn func(e *Event)
+}
+
+// Subscribe registers a function that is invoked for each event, returning a
+// function that removes it. The function is called synchronously from the
+// goroutine that caused the event and must not block.
+func (r *Registry) Subscribe(fn func(e *Event)) func() {
+	s := &subscription{fn: fn}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.subscriptions = append(r.subscriptions, s)
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for i, v := range r.subscriptions {
+			if v == s {
+				r.subscriptions = append(r.subscriptions[:i], r.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (r *Registry) publish(e *Event) {
+	r.mutex.RLock()
+	subscriptions := append([]*subscription{}, r.subscriptions...)
+	r.mutex.RUnlock()
+	for _, s := range subscriptions {
+		s.fn(e)
+	}
+}
+
+// publishLamps sends an event with the current state of the specified lamps
+// in the provider. The source is nil if the state was not changed.
+func (r *Registry) publishLamps(p Provider, keys map[lampKey]bool, source *Source) {
+	if len(keys) == 0 {
+		return
+	}
+	lamps := []*Lamp{}
+	for _, l := range r.Lamps(p) {
+		if keys[lampKey{p.ID(), l.GroupID, l.ID}] {
+			lamps = append(lamps, l)
+		}
+	}
+	if len(lamps) == 0 {
+		return
+	}
+	r.publish(&Event{
+		Type:       EventLamps,
+		ProviderID: p.ID(),
+		Lamps:      lamps,
+		Source:     source,
+	})
+}
+
+// pendingFrames are the lamps in a provider changed by frames since the
+// last event was sent for them.
+type pendingFrames struct {
+	keys   map[lampKey]bool
+	source *Source
+}
+
+// publishChanges sends an event for the lamps changed with the context.
+// Events for frames are coalesced so that clients and subscribers receive
+// at most one for each provider per frameEventInterval, the last of which
+// has the state that the lamps were left in.
+func (r *Registry) publishChanges(ctx context.Context, p Provider, keys map[lampKey]bool) {
+	if !frame(ctx) {
+		r.publishLamps(p, keys, SourceFrom(ctx))
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	r.frameMutex.Lock()
+	defer r.frameMutex.Unlock()
+	v, ok := r.frames[p.ID()]
+	if !ok {
+		v = &pendingFrames{keys: map[lampKey]bool{}}
+		r.frames[p.ID()] = v
+		time.AfterFunc(frameEventInterval, func() {
+			r.publishFrames(p)
+		})
+	}
+	for k := range keys {
+		v.keys[k] = true
+	}
+	v.source = SourceFrom(ctx)
+}
+
+// publishFrames sends the event for the lamps changed by frames, provided
+// that the provider is still registered.
+func (r *Registry) publishFrames(p Provider) {
+	r.frameMutex.Lock()
+	v := r.frames[p.ID()]
+	delete(r.frames, p.ID())
+	r.frameMutex.Unlock()
+	if current, err := r.GetProvider(p.ID()); err != nil || current != p {
+		return
+	}
+	r.publishLamps(p, v.keys, v.source)
+}
+
+// publishProvider sends an event with all of the groups and lamps in the
+// provider.
+func (r *Registry) publishProvider(p Provider) {
+	r.publish(&Event{
+		Type:       EventProvider,
+		ProviderID: p.ID(),
+		Name:       p.Name(),
+		Groups:     p.Groups(),
+		Lamps:      r.Lamps(p),
+	})
+}
+
+// publishKey sends an event for a single lamp after something other than
+// its state, such as its tags, was changed.
+func (r *Registry) publishKey(k lampKey) {
+	p, err := r.GetProvider(k.ProviderID)
+	if err != nil {
+		return
+	}
+	r.publishLamps(p, map[lampKey]bool{k: true}, nil)
+}
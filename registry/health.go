@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDisabled = "disabled"
+
+	minRetryInterval = time.Second
+	maxRetryInterval = 5 * time.Minute
+
+	// healthCheckInterval determines how often providers are checked for
+	// changes to their health so that an event can be published
+	healthCheckInterval = 5 * time.Second
+)
+
+// ErrProviderUnavailable indicates that the provider has not (yet) been
+// successfully initialized.
+var ErrProviderUnavailable = errors.New("provider is unavailable")
+
+// Health describes whether a provider is working correctly.
+type Health struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Since     time.Time  `json:"since"`
+	NextRetry *time.Time `json:"next_retry,omitempty"`
+}
+
+// HealthReporter may be implemented by providers that are able to report
+// problems with their operation.
+type HealthReporter interface {
+	Health() *Health
+}
+
+// ProviderHealth returns the health of the provider, which is assumed to be
+// fine if it does not implement HealthReporter.
+func ProviderHealth(p Provider) *Health {
+	if v, ok := p.(HealthReporter); ok {
+		return v.Health()
+	}
+	return &Health{
+		Status: HealthOK,
+	}
+}
+
+// checkHealth publishes an event for each provider whose health status has
+// changed since the last check.
+func (r *Registry) checkHealth() {
+	providers := r.Providers()
+	seen := map[string]bool{}
+	for _, p := range providers {
+		h := ProviderHealth(p)
+		seen[p.ID()] = true
+		r.mutex.Lock()
+		old, ok := r.health[p.ID()]
+		r.health[p.ID()] = h.Status
+		r.mutex.Unlock()
+		if ok && old == h.Status {
+			continue
+		}
+		r.publish(&Event{
+			Type:       EventHealth,
+			ProviderID: p.ID(),
+			Name:       p.Name(),
+			Health:     h,
+		})
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id := range r.health {
+		if !seen[id] {
+			delete(r.health, id)
+		}
+	}
+}
+
+// NextRetryInterval doubles the interval between retries up to a maximum.
+func NextRetryInterval(d time.Duration) time.Duration {
+	if d < minRetryInterval {
+		return minRetryInterval
+	}
+	d *= 2
+	if d > maxRetryInterval {
+		d = maxRetryInterval
+	}
+	return d
+}
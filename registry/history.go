@@ -0,0 +1,241 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	registry_db "github.com/lampctl/lampctl/registry/db"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	defaultHistoryLimit     = 100
+	maxHistoryLimit         = 1000
+
+	// historyFlushInterval is the amount of time entries are buffered
+	// before being written together
+	historyFlushInterval = time.Second
+
+	// historyPruneInterval determines how often entries older than the
+	// retention period are removed
+	historyPruneInterval = time.Hour
+)
+
+// HistoryQuery filters the entries returned by History. Empty fields match
+// everything. Sources matches entries from any of the listed sources.
+type HistoryQuery struct {
+	ProviderID string
+	GroupID    string
+	LampID     string
+	Source     string
+	Sources    []string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// historyStore buffers history entries and writes them to the database in
+// batches, removing old entries periodically.
+type historyStore struct {
+	mutex      sync.Mutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	retention  time.Duration
+	pending    []*registry_db.History
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func newHistoryStore(
+	logger zerolog.Logger,
+	conn *db.Conn,
+	retention time.Duration,
+) (*historyStore, error) {
+	if err := conn.AutoMigrate(&registry_db.History{}); err != nil {
+		return nil, err
+	}
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+	h := &historyStore{
+		logger:     logger,
+		db:         conn,
+		retention:  retention,
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	go h.run()
+	return h, nil
+}
+
+func (h *historyStore) run() {
+	defer close(h.closedChan)
+	defer h.flush()
+	pruneTicker := time.NewTicker(historyPruneInterval)
+	defer pruneTicker.Stop()
+	h.prune()
+	for {
+		select {
+		case <-h.wakeChan:
+		case <-pruneTicker.C:
+			h.prune()
+			continue
+		case <-h.closeChan:
+			return
+		}
+		select {
+		case <-time.After(historyFlushInterval):
+		case <-h.closeChan:
+			return
+		}
+		h.flush()
+	}
+}
+
+func (h *historyStore) add(entries []*registry_db.History) {
+	if len(entries) == 0 {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.pending = append(h.pending, entries...)
+	select {
+	case h.wakeChan <- nil:
+	default:
+	}
+}
+
+// flush writes all of the pending entries.
+func (h *historyStore) flush() {
+	h.mutex.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.mutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if err := h.db.CreateInBatches(pending, 100).Error; err != nil {
+		h.logger.Error().Msg(err.Error())
+	}
+}
+
+// prune removes entries older than the retention period.
+func (h *historyStore) prune() {
+	if err := h.db.
+		Where("time < ?", time.Now().Add(-h.retention).UTC()).
+		Delete(&registry_db.History{}).Error; err != nil {
+		h.logger.Error().Msg(err.Error())
+	}
+}
+
+func (h *historyStore) close() {
+	close(h.closeChan)
+	<-h.closedChan
+}
+
+func newHistory(
+	now time.Time,
+	source *Source,
+	providerID, groupID, lampID string,
+) *registry_db.History {
+	return &registry_db.History{
+		Time:       now.UTC(),
+		ProviderID: providerID,
+		GroupID:    groupID,
+		LampID:     lampID,
+		Source:     source.Type,
+		SourceID:   source.ID,
+	}
+}
+
+// recorded determines whether changes made with the context are added to
+// the history. Frames are excluded, so only the start and stop of effects
+// and sequences are recorded with RecordHistory.
+func recorded(ctx context.Context) bool {
+	return !frame(ctx)
+}
+
+// recordHistory adds an entry for each change using the source attached to
+// the context.
+func (r *Registry) recordHistory(ctx context.Context, providerID string, changes []*Change) {
+	if !recorded(ctx) {
+		return
+	}
+	var (
+		now     = time.Now()
+		source  = SourceFrom(ctx)
+		entries = []*registry_db.History{}
+	)
+	for _, c := range changes {
+		v := newHistory(now, source, providerID, c.GroupID, c.LampID)
+		v.State = c.State
+		v.Brightness = c.Brightness
+		v.Duration = c.Duration
+		if c.Color != nil {
+			v.Color = c.Color.String()
+		}
+		entries = append(entries, v)
+	}
+	r.history.add(entries)
+}
+
+// RecordHistory adds an entry with the provided detail for each of the
+// targets. This is used for activity whose individual changes are not
+// recorded, such as effects and the sequencer.
+func (r *Registry) RecordHistory(ctx context.Context, targets []*Target, detail string) {
+	var (
+		now     = time.Now()
+		source  = SourceFrom(ctx)
+		entries = []*registry_db.History{}
+	)
+	for _, t := range targets {
+		v := newHistory(now, source, t.ProviderID, t.GroupID, t.LampID)
+		v.Detail = detail
+		entries = append(entries, v)
+	}
+	r.history.add(entries)
+}
+
+// History returns the entries matching the query, newest first.
+func (r *Registry) History(q *HistoryQuery) ([]*registry_db.History, error) {
+	r.history.flush()
+	tx := r.db.Order("time DESC, id DESC")
+	if q.ProviderID != "" {
+		tx = tx.Where("provider_id = ?", q.ProviderID)
+	}
+	if q.GroupID != "" {
+		tx = tx.Where("group_id = ?", q.GroupID)
+	}
+	if q.LampID != "" {
+		tx = tx.Where("lamp_id = ?", q.LampID)
+	}
+	if q.Source != "" {
+		tx = tx.Where("source = ?", q.Source)
+	}
+	if len(q.Sources) > 0 {
+		tx = tx.Where("source IN ?", q.Sources)
+	}
+	if !q.Since.IsZero() {
+		tx = tx.Where("time >= ?", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		tx = tx.Where("time < ?", q.Until.UTC())
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	entries := []*registry_db.History{}
+	if err := tx.Limit(limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
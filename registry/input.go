@@ -0,0 +1,26 @@
+package registry
+
+const InputPress = "press"
+
+// Input describes the use of an input, such as a button, reported by a
+// provider. Action distinguishes different uses of the same input, such as
+// a short or long press, and defaults to a press.
+type Input struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+// PressInput publishes an event indicating that the input was used.
+func (r *Registry) PressInput(providerID, id, action string) {
+	if action == "" {
+		action = InputPress
+	}
+	r.publish(&Event{
+		Type:       EventInput,
+		ProviderID: providerID,
+		Input: &Input{
+			ID:     id,
+			Action: action,
+		},
+	})
+}
@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"sort"
+
+	registry_db "github.com/lampctl/lampctl/registry/db"
+)
+
+// loadMetadata reads all of the lamp metadata from the database.
+func (r *Registry) loadMetadata() error {
+	if err := r.db.AutoMigrate(&registry_db.Metadata{}); err != nil {
+		return err
+	}
+	metadata := []*registry_db.Metadata{}
+	if err := r.db.Find(&metadata).Error; err != nil {
+		return err
+	}
+	for _, m := range metadata {
+		r.metadata[lampKey{m.ProviderID, m.GroupID, m.LampID}] = m
+	}
+	return nil
+}
+
+// Metadata returns the metadata for every lamp that has any.
+func (r *Registry) Metadata() []*registry_db.Metadata {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	metadata := []*registry_db.Metadata{}
+	for _, m := range r.metadata {
+		v := *m
+		metadata = append(metadata, &v)
+	}
+	sort.Slice(metadata, func(i, j int) bool {
+		a, b := metadata[i], metadata[j]
+		if a.ProviderID != b.ProviderID {
+			return a.ProviderID < b.ProviderID
+		}
+		if a.GroupID != b.GroupID {
+			return a.GroupID < b.GroupID
+		}
+		return a.LampID < b.LampID
+	})
+	return metadata
+}
+
+// SetMetadata stores the metadata for a lamp, replacing any that exists.
+func (r *Registry) SetMetadata(m *registry_db.Metadata) error {
+	if m.ProviderID == "" || m.GroupID == "" || m.LampID == "" {
+		return Rejectf("provider, group, and lamp are required")
+	}
+	if m.Wattage < 0 {
+		return Rejectf("wattage cannot be negative")
+	}
+	if err := r.db.Save(m).Error; err != nil {
+		return err
+	}
+	var (
+		k = lampKey{m.ProviderID, m.GroupID, m.LampID}
+		v = *m
+	)
+	r.mutex.Lock()
+	r.metadata[k] = &v
+	r.mutex.Unlock()
+	r.publishKey(k)
+	return nil
+}
+
+// DeleteMetadata removes the metadata for a lamp.
+func (r *Registry) DeleteMetadata(providerID, groupID, lampID string) error {
+	if err := r.db.Delete(&registry_db.Metadata{
+		ProviderID: providerID,
+		GroupID:    groupID,
+		LampID:     lampID,
+	}).Error; err != nil {
+		return err
+	}
+	k := lampKey{providerID, groupID, lampID}
+	r.mutex.Lock()
+	delete(r.metadata, k)
+	r.mutex.Unlock()
+	r.publishKey(k)
+	return nil
+}
+
+// decorate returns a copy of the lamp with its tags and metadata applied.
+func (r *Registry) decorate(providerID string, l *Lamp) *Lamp {
+	k := lampKey{providerID, l.GroupID, l.ID}
+	v := *l
+	v.Tags = r.lampTags(k)
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if m, ok := r.metadata[k]; ok {
+		if m.Name != "" {
+			v.Name = m.Name
+		}
+		v.Icon = m.Icon
+		v.Location = m.Location
+		v.Notes = m.Notes
+		v.Wattage = m.Wattage
+	}
+	v.Enforced = r.enforced[k]
+	return &v
+}
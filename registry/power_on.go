@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"context"
+	"sort"
+
+	registry_db "github.com/lampctl/lampctl/registry/db"
+)
+
+const (
+	PowerOnLast  = "last"
+	PowerOnOff   = "off"
+	PowerOnOn    = "on"
+	PowerOnScene = "scene"
+)
+
+// loadPowerOn reads the power-on behavior for each lamp from the database.
+func (r *Registry) loadPowerOn() error {
+	if err := r.db.AutoMigrate(
+		&registry_db.PowerOn{},
+		&registry_db.Scene{},
+	); err != nil {
+		return err
+	}
+	powerOn := []*registry_db.PowerOn{}
+	if err := r.db.Find(&powerOn).Error; err != nil {
+		return err
+	}
+	for _, v := range powerOn {
+		r.powerOn[lampKey{v.ProviderID, v.GroupID, v.LampID}] = v
+	}
+	return nil
+}
+
+// PowerOn returns the power-on behavior for every lamp that does not use the
+// default, which is to restore the last state.
+func (r *Registry) PowerOn() []*registry_db.PowerOn {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	powerOn := []*registry_db.PowerOn{}
+	for _, v := range r.powerOn {
+		p := *v
+		powerOn = append(powerOn, &p)
+	}
+	sort.Slice(powerOn, func(i, j int) bool {
+		a, b := powerOn[i], powerOn[j]
+		if a.ProviderID != b.ProviderID {
+			return a.ProviderID < b.ProviderID
+		}
+		if a.GroupID != b.GroupID {
+			return a.GroupID < b.GroupID
+		}
+		return a.LampID < b.LampID
+	})
+	return powerOn
+}
+
+// SetPowerOn stores the power-on behavior for a lamp.
+func (r *Registry) SetPowerOn(v *registry_db.PowerOn) error {
+	if v.ProviderID == "" || v.GroupID == "" || v.LampID == "" {
+		return Rejectf("provider, group, and lamp are required")
+	}
+	switch v.Behavior {
+	case PowerOnLast, PowerOnOff, PowerOnOn:
+		v.SceneID = 0
+	case PowerOnScene:
+		if _, err := r.Scene(v.SceneID); err != nil {
+			return err
+		}
+	default:
+		return Rejectf("invalid power-on behavior %q", v.Behavior)
+	}
+	if err := r.db.Save(v).Error; err != nil {
+		return err
+	}
+	p := *v
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.powerOn[lampKey{v.ProviderID, v.GroupID, v.LampID}] = &p
+	return nil
+}
+
+// DeletePowerOn resets the power-on behavior for a lamp to the default.
+func (r *Registry) DeletePowerOn(providerID, groupID, lampID string) error {
+	if err := r.db.Delete(&registry_db.PowerOn{
+		ProviderID: providerID,
+		GroupID:    groupID,
+		LampID:     lampID,
+	}).Error; err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.powerOn, lampKey{providerID, groupID, lampID})
+	return nil
+}
+
+// lastChange creates a change that returns the lamp to its last recorded
+// state, optionally overriding whether it is on.
+func (r *Registry) lastChange(k lampKey, state *bool) *Change {
+	s, ok := r.states.get(k)
+	if !ok && state == nil {
+		return nil
+	}
+	c := &Change{
+		GroupID: k.GroupID,
+		LampID:  k.LampID,
+	}
+	if ok {
+		c.State = s.State
+		c.Brightness = s.Brightness
+		if s.Color != "" {
+			if v, err := ParseColor(s.Color); err == nil {
+				c.Color = v
+			}
+		}
+	}
+	if state != nil {
+		c.State = *state
+	}
+	return c
+}
+
+// sceneChange finds the change for the lamp in the scene, if any.
+func (r *Registry) sceneChange(k lampKey, sceneID int64) *Change {
+	s, err := r.Scene(sceneID)
+	if err != nil {
+		return nil
+	}
+	changes, _ := r.expand(s.Changes, k.ProviderID)
+	var found *Change
+	for _, c := range changes {
+		if c.ProviderID == k.ProviderID &&
+			c.GroupID == k.GroupID &&
+			c.LampID == k.LampID {
+			found = c
+		}
+	}
+	if found != nil {
+		v := *found
+		v.Duration = 0
+		return &v
+	}
+	return nil
+}
+
+// restore applies the power-on behavior to each lamp in the provider. Lamps
+// without a recorded state are left as the provider initialized them.
+// Pending timers for the provider are applied afterwards.
+func (r *Registry) restore(p Provider) {
+	defer r.timersReady(p.ID(), true)
+	changes := []*Change{}
+	for _, l := range p.Lamps() {
+		k := lampKey{p.ID(), l.GroupID, l.ID}
+		r.mutex.RLock()
+		v, ok := r.powerOn[k]
+		r.mutex.RUnlock()
+		behavior := PowerOnLast
+		if ok {
+			behavior = v.Behavior
+		}
+		var c *Change
+		switch behavior {
+		case PowerOnOff:
+			off := false
+			c = r.lastChange(k, &off)
+		case PowerOnOn:
+			on := true
+			c = r.lastChange(k, &on)
+		case PowerOnScene:
+			c = r.sceneChange(k, v.SceneID)
+		}
+		if c == nil {
+			c = r.lastChange(k, nil)
+		}
+		if c != nil {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	ctx := WithSource(context.Background(), SourceSystem, "restore")
+	results, err := r.Apply(ctx, p.ID(), changes)
+	if err == nil {
+		err = Err(results)
+	}
+	if err != nil {
+		r.logger.Error().Str("provider", p.ID()).Msg(err.Error())
+		return
+	}
+	r.logger.Info().Str("provider", p.ID()).Msgf("restored %d lamp(s)", len(changes))
+}
@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 
 	"github.com/gin-gonic/gin"
@@ -19,21 +21,67 @@ type Group struct {
 }
 
 // Lamp provides information about a specific lamp that can be controlled.
+// Tags and the fields after them are filled in by the registry from what the
+// user has provided and need not be set by providers.
 type Lamp struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	GroupID string `json:"group_id"`
-	State   bool   `json:"state"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	GroupID  string   `json:"group_id"`
+	State    bool     `json:"state"`
+	Tags     []string `json:"tags,omitempty"`
+	Icon     string   `json:"icon,omitempty"`
+	Location string   `json:"location,omitempty"`
+	Notes    string   `json:"notes,omitempty"`
+	Wattage  float64  `json:"wattage,omitempty"`
+	Enforced bool     `json:"enforced,omitempty"`
 }
 
-// Change represents a request to change the state of a lamp.
+// Change represents a request to change the state of a lamp. Duration is
+// specified in milliseconds and Brightness ranges from 0 to 1, with zero
+// indicating full brightness. ProviderID is only required when changes for
+// multiple providers are applied together. If Selector is provided, the
+// change is applied to every lamp it matches instead. If RevertAfter is
+// provided, the lamp returns to its previous state after that many
+// milliseconds. A Color of null or "" leaves the color unset.
 type Change struct {
-	GroupID    string  `json:"group_id"`
-	LampID     string  `json:"lamp_id"`
-	State      bool    `json:"state"`
-	Duration   int64   `json:"duration"`
-	Brightness float64 `json:"brightness"`
-	Color      string  `json:"color"`
+	ProviderID  string    `json:"provider_id,omitempty"`
+	GroupID     string    `json:"group_id"`
+	LampID      string    `json:"lamp_id"`
+	Selector    *Selector `json:"selector,omitempty"`
+	State       bool      `json:"state"`
+	Duration    int64     `json:"duration"`
+	Brightness  float64   `json:"brightness"`
+	Color       *Color    `json:"color"`
+	RevertAfter int64     `json:"revert_after,omitempty"`
+}
+
+// UnmarshalJSON decodes the change, treating an empty color string the same
+// as null since that is what older clients send when no color is set.
+func (c *Change) UnmarshalJSON(data []byte) error {
+	type change Change
+	v := struct {
+		*change
+		Color json.RawMessage `json:"color"`
+	}{change: (*change)(c)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch string(v.Color) {
+	case "":
+		return nil
+	case "null", `""`:
+		c.Color = nil
+		return nil
+	}
+	c.Color = &Color{}
+	return json.Unmarshal(v.Color, c.Color)
+}
+
+// Target identifies a single lamp across all providers.
+type Target struct {
+	ProviderID string `json:"provider_id"`
+	GroupID    string `json:"group_id"`
+	LampID     string `json:"lamp_id"`
 }
 
 // Provider represents a group of lamps. The interface provides methods for
@@ -58,9 +106,26 @@ type Provider interface {
 	// Lamps returns a list of all lamps managed by the provider.
 	Lamps() []*Lamp
 
-	// Apply applies a list of state changes to the lamps in the provider.
-	Apply(changes []*Change) error
+	// Apply applies a list of state changes to the lamps in the provider,
+	// returning the result of each change in the same order. A failed change
+	// must not prevent the remaining changes from being applied. Providers
+	// that communicate with devices must give up once the context is done.
+	Apply(ctx context.Context, changes []*Change) []*Result
 
 	// ApplyToAll applies a state change to all lamps in the provider.
-	ApplyToAll(change *Change) error
+	ApplyToAll(ctx context.Context, change *Change) error
+}
+
+// NativeTransitioner may be implemented by providers that are able to fade
+// between states on their own. Changes with a duration are passed through to
+// these providers unmodified instead of being rendered frame-by-frame.
+type NativeTransitioner interface {
+	NativeTransitions() bool
+}
+
+// Refresher may be implemented by providers whose lamps can be changed by
+// something else, such as a wall switch or another app. Refresh reads the
+// current state of each lamp from the devices so that Lamps() reports it.
+type Refresher interface {
+	Refresh(ctx context.Context) error
 }
@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	registry_db "github.com/lampctl/lampctl/registry/db"
+)
+
+const defaultReconcileInterval = 30 * time.Second
+
+// loadEnforced reads the list of enforced lamps from the database.
+func (r *Registry) loadEnforced() error {
+	if err := r.db.AutoMigrate(&registry_db.Enforced{}); err != nil {
+		return err
+	}
+	enforced := []*registry_db.Enforced{}
+	if err := r.db.Find(&enforced).Error; err != nil {
+		return err
+	}
+	for _, v := range enforced {
+		r.enforced[lampKey{v.ProviderID, v.GroupID, v.LampID}] = true
+	}
+	return nil
+}
+
+// Enforced returns every lamp whose state is enforced.
+func (r *Registry) Enforced() []*Target {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	targets := []*Target{}
+	for k := range r.enforced {
+		targets = append(targets, &Target{
+			ProviderID: k.ProviderID,
+			GroupID:    k.GroupID,
+			LampID:     k.LampID,
+		})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.ProviderID != b.ProviderID {
+			return a.ProviderID < b.ProviderID
+		}
+		if a.GroupID != b.GroupID {
+			return a.GroupID < b.GroupID
+		}
+		return a.LampID < b.LampID
+	})
+	return targets
+}
+
+// SetEnforced determines whether the lamp is returned to its desired state
+// when something else changes it.
+func (r *Registry) SetEnforced(t *Target, enforced bool) error {
+	if t.ProviderID == "" || t.GroupID == "" || t.LampID == "" {
+		return Rejectf("provider, group, and lamp are required")
+	}
+	v := &registry_db.Enforced{
+		ProviderID: t.ProviderID,
+		GroupID:    t.GroupID,
+		LampID:     t.LampID,
+	}
+	if enforced {
+		if err := r.db.Save(v).Error; err != nil {
+			return err
+		}
+	} else {
+		if err := r.db.Delete(v).Error; err != nil {
+			return err
+		}
+	}
+	r.mutex.Lock()
+	k := lampKey{t.ProviderID, t.GroupID, t.LampID}
+	if enforced {
+		r.enforced[k] = true
+	} else {
+		delete(r.enforced, k)
+	}
+	r.mutex.Unlock()
+	r.publishKey(k)
+	return nil
+}
+
+func (r *Registry) isEnforced(k lampKey) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.enforced[k]
+}
+
+// runMonitor periodically compares the desired state of each lamp with the
+// state reported by its provider and checks whether the health of each
+// provider has changed.
+func (r *Registry) runMonitor(interval time.Duration) {
+	defer close(r.monitorClosedChan)
+	var (
+		reconcileTicker = time.NewTicker(interval)
+		healthTicker    = time.NewTicker(healthCheckInterval)
+	)
+	defer reconcileTicker.Stop()
+	defer healthTicker.Stop()
+	for {
+		select {
+		case <-reconcileTicker.C:
+			for _, p := range r.Providers() {
+				r.reconcile(p)
+			}
+		case <-healthTicker.C:
+			r.checkHealth()
+		case <-r.monitorCloseChan:
+			return
+		}
+	}
+}
+
+// reconcile handles lamps in the provider that no longer match their
+// desired state. Enforced lamps are changed back; for all others, the
+// change is accepted as the new desired state. Lamps in the middle of a
+// transition are skipped since they are expected to differ, as are lamps
+// changed since reconciling started (including those with changes still
+// being applied) since the provider may not reflect the change yet.
+func (r *Registry) reconcile(p Provider) {
+	started := time.Now()
+	if v, ok := p.(Refresher); ok {
+		ctx, cancel := r.WithTimeout(context.Background(), p.ID())
+		err := v.Refresh(ctx)
+		cancel()
+		if err != nil {
+			r.logger.Error().Str("provider", p.ID()).Msg(err.Error())
+			return
+		}
+	}
+	var (
+		changes         = []*Change{}
+		accepted        = map[lampKey]bool{}
+		acceptedChanges = []*Change{}
+	)
+	for _, l := range p.Lamps() {
+		k := lampKey{p.ID(), l.GroupID, l.ID}
+		desired, ok := r.states.live(k)
+		if !ok || desired.State == l.State || r.transitioner.active(k) ||
+			r.states.changedSince(k, started) {
+			continue
+		}
+		if r.isEnforced(k) {
+			changes = append(changes, r.lastChange(k, nil))
+			continue
+		}
+		r.logger.Info().
+			Str("provider", p.ID()).
+			Str("group", l.GroupID).
+			Str("lamp", l.ID).
+			Bool("state", l.State).
+			Msg("lamp was changed externally")
+		c := r.lastChange(k, &l.State)
+		r.states.record(p.ID(), c)
+		accepted[k] = true
+		acceptedChanges = append(acceptedChanges, c)
+	}
+	externalCtx := WithSource(context.Background(), SourceExternal, "")
+	r.recordHistory(externalCtx, p.ID(), acceptedChanges)
+	r.updateTimers(externalCtx, p.ID(), acceptedChanges, nil)
+	r.publishLamps(p, accepted, SourceFrom(externalCtx))
+	if len(changes) == 0 {
+		return
+	}
+	ctx := WithSource(context.Background(), SourceSystem, "reconcile")
+	results, err := r.Apply(ctx, p.ID(), changes)
+	if err == nil {
+		err = Err(results)
+	}
+	if err != nil {
+		r.logger.Error().Str("provider", p.ID()).Msg(err.Error())
+		return
+	}
+	r.logger.Info().Str("provider", p.ID()).Msgf("enforced %d lamp(s)", len(changes))
+}
@@ -1,48 +1,619 @@
 package registry
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	registry_db "github.com/lampctl/lampctl/registry/db"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultFrameRate = 30
+	defaultTimeout   = 10 * time.Second
+)
+
+var (
+	ErrInvalidProvider = errors.New("invalid provider specified")
+	ErrInvalidTimeout  = errors.New("timeout must be greater than zero")
+
+	errMissingResult = errors.New("provider did not report a result")
 )
 
-var errInvalidProvider = errors.New("invalid provider specified")
+// Definition describes a provider that can be enabled and disabled while the
+// application is running. Timeout is the default time allowed for applying
+// changes, which can be overridden at runtime.
+type Definition struct {
+	ID      string
+	Name    string
+	Timeout time.Duration
+	Factory Factory
+}
 
 // Registry maintains a list of providers and provides access to them.
 type Registry struct {
-	providers map[string]Provider
+	mutex        sync.RWMutex
+	logger       zerolog.Logger
+	db           *db.Conn
+	definitions  map[string]*Definition
+	providers    map[string]Provider
+	timeouts     map[string]time.Duration
+	tags         map[lampKey][]string
+	metadata     map[lampKey]*registry_db.Metadata
+	powerOn      map[lampKey]*registry_db.PowerOn
+	enforced     map[lampKey]bool
+	maxOn        map[lampKey]*registry_db.MaxOn
+	states       *stateStore
+	history      *historyStore
+	transitioner *transitioner
+	watchers     []func()
+
+	subscriptions []*subscription
+	health        map[string]string
+
+	frameMutex sync.Mutex
+	frames     map[string]*pendingFrames
+
+	// enableMutex is held while providers are defined, enabled, or disabled
+	// so that checking whether one is running and registering it happen
+	// together
+	enableMutex sync.Mutex
+
+	undoMutex sync.Mutex
+	undo      []*undoBatch
+	redo      []*undoBatch
+
+	timerMutex      sync.Mutex
+	timers          map[lampKey]*Timer
+	timerProviders  map[string]bool
+	timerWakeChan   chan any
+	timerCloseChan  chan any
+	timerClosedChan chan any
+
+	monitorCloseChan  chan any
+	monitorClosedChan chan any
 }
 
 // New creates and initializes a new Registry instance.
-func New() *Registry {
-	return &Registry{
-		providers: make(map[string]Provider),
+func New(cfg *Config) (*Registry, error) {
+	frameRate := cfg.FrameRate
+	if frameRate <= 0 {
+		frameRate = defaultFrameRate
+	}
+	reconcileInterval := cfg.ReconcileInterval
+	if reconcileInterval <= 0 {
+		reconcileInterval = defaultReconcileInterval
+	}
+	r := &Registry{
+		logger:      log.With().Str("package", "registry").Logger(),
+		db:          cfg.DB,
+		definitions: make(map[string]*Definition),
+		providers:   make(map[string]Provider),
+		timeouts:    make(map[string]time.Duration),
+		tags:        make(map[lampKey][]string),
+		metadata:    make(map[lampKey]*registry_db.Metadata),
+		powerOn:     make(map[lampKey]*registry_db.PowerOn),
+		enforced:    make(map[lampKey]bool),
+		maxOn:       make(map[lampKey]*registry_db.MaxOn),
+		health:      make(map[string]string),
+		frames:      make(map[string]*pendingFrames),
+
+		timers:          make(map[lampKey]*Timer),
+		timerProviders:  make(map[string]bool),
+		timerWakeChan:   make(chan any, 1),
+		timerCloseChan:  make(chan any),
+		timerClosedChan: make(chan any),
+
+		monitorCloseChan:  make(chan any),
+		monitorClosedChan: make(chan any),
+	}
+	if err := r.loadTags(); err != nil {
+		return nil, err
+	}
+	if err := r.loadMetadata(); err != nil {
+		return nil, err
+	}
+	if err := r.loadPowerOn(); err != nil {
+		return nil, err
+	}
+	if err := r.loadEnforced(); err != nil {
+		return nil, err
+	}
+	if err := r.loadTimers(); err != nil {
+		return nil, err
+	}
+	states, err := newStateStore(r.logger, r.db)
+	if err != nil {
+		return nil, err
+	}
+	r.states = states
+	history, err := newHistoryStore(r.logger, r.db, cfg.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	r.history = history
+	r.transitioner = newTransitioner(r.logger, frameRate, r.Timeout)
+	go r.runMonitor(reconcileInterval)
+	go r.runTimers()
+	return r, nil
+}
+
+func enabledKey(id string) string {
+	return fmt.Sprintf("provider.%s.enabled", id)
+}
+
+func timeoutKey(id string) string {
+	return fmt.Sprintf("provider.%s.timeout", id)
+}
+
+// Define adds a provider that can be enabled and disabled at runtime. If the
+// provider is enabled (the default), it is created and registered.
+func (r *Registry) Define(d *Definition) error {
+	r.enableMutex.Lock()
+	defer r.enableMutex.Unlock()
+	enabled, err := r.db.GetBoolSetting(enabledKey(d.ID), true)
+	if err != nil {
+		return err
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ms, err := r.db.GetIntSetting(timeoutKey(d.ID), int(timeout/time.Millisecond))
+	if err != nil {
+		return err
+	}
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.definitions[d.ID] = d
+		r.timeouts[d.ID] = time.Duration(ms) * time.Millisecond
+	}()
+	if enabled {
+		r.Register(NewSupervised(d.ID, d.Name, d.Factory))
+	}
+	return nil
+}
+
+// Definitions returns all providers that were added with Define.
+func (r *Registry) Definitions() []*Definition {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	definitions := []*Definition{}
+	for _, d := range r.definitions {
+		definitions = append(definitions, d)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].ID < definitions[j].ID
+	})
+	return definitions
+}
+
+// Enable stores the provider as enabled and, if it is not already running,
+// creates and registers it.
+func (r *Registry) Enable(id string) error {
+	r.enableMutex.Lock()
+	defer r.enableMutex.Unlock()
+	r.mutex.RLock()
+	d, ok := r.definitions[id]
+	_, running := r.providers[id]
+	r.mutex.RUnlock()
+	if !ok {
+		return ErrInvalidProvider
+	}
+	if err := r.db.SetBoolSetting(enabledKey(id), true); err != nil {
+		return err
+	}
+	if !running {
+		r.Register(NewSupervised(d.ID, d.Name, d.Factory))
+	}
+	return nil
+}
+
+// Disable stores the provider as disabled and unregisters it if running.
+func (r *Registry) Disable(id string) error {
+	r.enableMutex.Lock()
+	defer r.enableMutex.Unlock()
+	r.mutex.RLock()
+	_, ok := r.definitions[id]
+	_, running := r.providers[id]
+	r.mutex.RUnlock()
+	if !ok {
+		return ErrInvalidProvider
+	}
+	if err := r.db.SetBoolSetting(enabledKey(id), false); err != nil {
+		return err
+	}
+	if running {
+		return r.Unregister(id)
+	}
+	return nil
+}
+
+// Timeout returns the maximum amount of time allowed for applying changes to
+// the specified provider.
+func (r *Registry) Timeout(id string) time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if v, ok := r.timeouts[id]; ok {
+		return v
 	}
+	return defaultTimeout
 }
 
-// Register adds a provider to the registry.
+// SetTimeout stores a new timeout for the specified provider.
+func (r *Registry) SetTimeout(id string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return ErrInvalidTimeout
+	}
+	r.mutex.RLock()
+	_, ok := r.definitions[id]
+	r.mutex.RUnlock()
+	if !ok {
+		return ErrInvalidProvider
+	}
+	if err := r.db.SetIntSetting(timeoutKey(id), int(timeout/time.Millisecond)); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.timeouts[id] = timeout
+	return nil
+}
+
+// WithTimeout derives a context from the provided one that expires after the
+// timeout for the specified provider.
+func (r *Registry) WithTimeout(
+	ctx context.Context,
+	id string,
+) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.Timeout(id))
+}
+
+// Register adds a provider to the registry, replacing any existing provider
+// with the same ID. Watchers are notified of the change, as well as when a
+// supervised provider becomes available later. Once the provider is
+// available, the power-on behavior of its lamps is applied.
 func (r *Registry) Register(provider Provider) {
-	r.providers[provider.ID()] = provider
+	if s, ok := provider.(*Supervised); ok {
+		s.setReadyHandler(func() {
+			r.logger.Info().Str("provider", s.ID()).Msg("provider is now available")
+			r.notify()
+			r.publishProvider(s)
+			r.restore(s)
+		})
+	}
+	old := func() Provider {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		old := r.providers[provider.ID()]
+		r.providers[provider.ID()] = provider
+		return old
+	}()
+	if old != nil {
+		r.transitioner.forget(old.ID())
+		old.Close()
+	}
+	r.timersReady(provider.ID(), false)
+	r.logger.Info().Str("provider", provider.ID()).Msg("provider registered")
+	r.notify()
+	r.publishProvider(provider)
+	go r.restore(provider)
+}
+
+// Unregister removes the provider from the registry and closes it.
+func (r *Registry) Unregister(id string) error {
+	p, err := func() (Provider, error) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		p, ok := r.providers[id]
+		if !ok {
+			return nil, ErrInvalidProvider
+		}
+		delete(r.providers, id)
+		return p, nil
+	}()
+	if err != nil {
+		return err
+	}
+	r.transitioner.forget(id)
+	r.timersReady(id, false)
+	p.Close()
+	r.logger.Info().Str("provider", id).Msg("provider unregistered")
+	r.notify()
+	r.publish(&Event{
+		Type:       EventProvider,
+		ProviderID: id,
+		Name:       p.Name(),
+		Removed:    true,
+	})
+	return nil
+}
+
+// Watch registers a function that is invoked whenever the available
+// providers change, allowing API routes to be rebuilt.
+func (r *Registry) Watch(fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.watchers = append(r.watchers, fn)
+}
+
+func (r *Registry) notify() {
+	r.mutex.RLock()
+	watchers := append([]func(){}, r.watchers...)
+	r.mutex.RUnlock()
+	for _, fn := range watchers {
+		fn()
+	}
 }
 
 // Providers returns a slice of all registered providers.
 func (r *Registry) Providers() []Provider {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	providers := []Provider{}
 	for _, p := range r.providers {
 		providers = append(providers, p)
 	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].ID() < providers[j].ID()
+	})
 	return providers
 }
 
+// Lamps returns the lamps in the provider with their tags and any metadata
+// provided by the user merged in.
+func (r *Registry) Lamps(p Provider) []*Lamp {
+	lamps := []*Lamp{}
+	for _, l := range p.Lamps() {
+		lamps = append(lamps, r.decorate(p.ID(), l))
+	}
+	return lamps
+}
+
 // GetProvider retrieves the specified provider by its ID.
 func (r *Registry) GetProvider(id string) (Provider, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	p, ok := r.providers[id]
 	if !ok {
-		return nil, errInvalidProvider
+		return nil, ErrInvalidProvider
 	}
 	return p, nil
 }
 
+func hasNativeTransitions(p Provider) bool {
+	v, ok := p.(NativeTransitioner)
+	return ok && v.NativeTransitions()
+}
+
+// Apply applies a list of state changes to the specified provider and
+// returns the result of each change in the same order. Changes with a
+// selector are expanded to one change for each matching lamp in the provider
+// and those matching nothing are rejected at the end of the results. Changes
+// with a duration are rendered as a series of frames for providers that
+// cannot fade on their own. Any change to a lamp cancels a transition that is
+// already in progress for it. The state of each lamp that was changed is
+// saved so that it can be restored later and published to subscribers, and
+// each applied change is added to the history along with the source attached
+// to the context. An error is returned only if the provider does not exist.
+func (r *Registry) Apply(
+	ctx context.Context,
+	providerID string,
+	changes []*Change,
+) ([]*Result, error) {
+	p, err := r.GetProvider(providerID)
+	if err != nil {
+		return nil, err
+	}
+	changes, rejected := r.expand(changes, providerID)
+	defer r.states.begin(providerID, changes)()
+	ctx, batch, owned := r.beginBatch(ctx)
+	var before map[lampKey]*Change
+	if batch != nil {
+		before = r.beforeChanges(p, changes)
+	}
+	reverts := r.revertChanges(p, changes)
+	ctx, cancel := r.WithTimeout(ctx, providerID)
+	defer cancel()
+	var (
+		native       = hasNativeTransitions(p)
+		instant      = []*Change{}
+		instantIndex = []int{}
+		faded        = []*Change{}
+		fadedIndex   = []int{}
+		results      = make([]*Result, len(changes))
+	)
+	for i, c := range changes {
+		if c.Duration > 0 && !native {
+			faded = append(faded, c)
+			fadedIndex = append(fadedIndex, i)
+		} else {
+			instant = append(instant, c)
+			instantIndex = append(instantIndex, i)
+		}
+	}
+	merge := func(changes []*Change, index []int, v []*Result) {
+		for i, c := range changes {
+			if i < len(v) {
+				results[index[i]] = v[i]
+			} else {
+				results[index[i]] = NewResult(c, errMissingResult)
+			}
+			results[index[i]].ProviderID = providerID
+		}
+	}
+	if len(instant) > 0 {
+		r.transitioner.record(p, instant)
+		merge(instant, instantIndex, p.Apply(ctx, instant))
+	}
+	if len(faded) > 0 {
+		merge(faded, fadedIndex, r.transitioner.start(ctx, p, faded))
+	}
+	var (
+		applied        = map[lampKey]bool{}
+		appliedChanges = []*Change{}
+	)
+	for i, c := range changes {
+		if results[i].Status == ResultApplied {
+			k := lampKey{providerID, c.GroupID, c.LampID}
+			r.recordState(ctx, providerID, c)
+			applied[k] = true
+			appliedChanges = append(appliedChanges, c)
+			if batch != nil && before[k] != nil {
+				batch.add(k, before[k], c)
+			}
+		}
+	}
+	r.recordHistory(ctx, providerID, appliedChanges)
+	r.updateTimers(ctx, providerID, appliedChanges, reverts)
+	r.publishChanges(ctx, p, applied)
+	if owned {
+		r.endBatch(batch)
+	}
+	return append(results, rejected...), nil
+}
+
+// ApplyChanges applies changes addressed to any number of providers by their
+// ProviderID or a selector. The changes for each provider are applied
+// concurrently so that lamps from different providers switch at the same
+// time. The result of each change is returned in the same order, followed by
+// any selectors that did not match.
+func (r *Registry) ApplyChanges(ctx context.Context, changes []*Change) []*Result {
+	changes, rejected := r.expand(changes, "")
+	ctx, batch, owned := r.beginBatch(ctx)
+	var (
+		results = make([]*Result, len(changes))
+		byID    = map[string][]*Change{}
+		index   = map[string][]int{}
+		wg      sync.WaitGroup
+	)
+	for i, c := range changes {
+		byID[c.ProviderID] = append(byID[c.ProviderID], c)
+		index[c.ProviderID] = append(index[c.ProviderID], i)
+	}
+	for id, c := range byID {
+		wg.Add(1)
+		go func(id string, c []*Change) {
+			defer wg.Done()
+			v, err := r.Apply(ctx, id, c)
+			if err != nil {
+				v = NewResults(c, err)
+			}
+			for i, res := range v {
+				results[index[id][i]] = res
+			}
+		}(id, c)
+	}
+	wg.Wait()
+	if owned {
+		r.endBatch(batch)
+	}
+	return append(results, rejected...)
+}
+
+// ApplyToAll applies a state change to all lamps in the specified provider.
+func (r *Registry) ApplyToAll(
+	ctx context.Context,
+	providerID string,
+	change *Change,
+) error {
+	p, err := r.GetProvider(providerID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := r.WithTimeout(ctx, providerID)
+	defer cancel()
+	changes := []*Change{}
+	for _, l := range p.Lamps() {
+		c := *change
+		c.GroupID = l.GroupID
+		c.LampID = l.ID
+		changes = append(changes, &c)
+	}
+	defer r.states.begin(providerID, changes)()
+	ctx, batch, owned := r.beginBatch(ctx)
+	var before map[lampKey]*Change
+	if batch != nil {
+		before = r.beforeChanges(p, changes)
+	}
+	reverts := r.revertChanges(p, changes)
+	if change.Duration > 0 && !hasNativeTransitions(p) {
+		results := r.transitioner.start(ctx, p, changes)
+		applied := []*Change{}
+		for i, c := range changes {
+			if i < len(results) && results[i].Status == ResultApplied {
+				applied = append(applied, c)
+			}
+		}
+		r.appliedToAll(ctx, p, applied, batch, before, reverts)
+		if owned {
+			r.endBatch(batch)
+		}
+		return Err(results)
+	}
+	r.transitioner.record(p, changes)
+	if err := p.ApplyToAll(ctx, change); err != nil {
+		return err
+	}
+	r.appliedToAll(ctx, p, changes, batch, before, reverts)
+	if owned {
+		r.endBatch(batch)
+	}
+	return nil
+}
+
+// appliedToAll records and publishes the changes applied by ApplyToAll.
+func (r *Registry) appliedToAll(
+	ctx context.Context,
+	p Provider,
+	changes []*Change,
+	batch *undoBatch,
+	before map[lampKey]*Change,
+	reverts map[lampKey]*Change,
+) {
+	applied := map[lampKey]bool{}
+	for _, c := range changes {
+		k := lampKey{p.ID(), c.GroupID, c.LampID}
+		r.recordState(ctx, p.ID(), c)
+		applied[k] = true
+		if batch != nil && before[k] != nil {
+			batch.add(k, before[k], c)
+		}
+	}
+	r.recordHistory(ctx, p.ID(), changes)
+	r.updateTimers(ctx, p.ID(), changes, reverts)
+	r.publishChanges(ctx, p, applied)
+}
+
+// recordState remembers the state that the change applied with the context
+// set the lamp to.
+func (r *Registry) recordState(ctx context.Context, providerID string, c *Change) {
+	if frame(ctx) {
+		r.states.recordFrame(providerID, c)
+		return
+	}
+	r.states.record(providerID, c)
+}
+
 // Close frees all providers and resources used by the registry.
 func (r *Registry) Close() {
+	close(r.monitorCloseChan)
+	<-r.monitorClosedChan
+	close(r.timerCloseChan)
+	<-r.timerClosedChan
+	r.transitioner.close()
+	r.states.close()
+	r.history.close()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	for _, v := range r.providers {
 		v.Close()
 	}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ResultApplied  = "applied"
+	ResultRejected = "rejected"
+	ResultFailed   = "failed"
+)
+
+// Result describes the outcome of applying a single change. A change is
+// rejected if it is invalid (for example, the lamp does not exist) and fails
+// if it is valid but the provider was unable to apply it.
+type Result struct {
+	ProviderID string    `json:"provider_id,omitempty"`
+	GroupID    string    `json:"group_id"`
+	LampID     string    `json:"lamp_id"`
+	Selector   *Selector `json:"selector,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// RejectedError indicates that a change was invalid and retrying it will not
+// help. Errors wrapping ErrInvalidProvider, ErrInvalidGroup, ErrInvalidLamp,
+// ErrInvalidColor, ErrInvalidSelector, or ErrNoMatch are also treated as
+// rejections.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
+}
+
+// Rejectf creates a RejectedError using the provided format string.
+func Rejectf(format string, a ...interface{}) error {
+	return &RejectedError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// IsRejection determines whether the error indicates an invalid change.
+func IsRejection(err error) bool {
+	var r *RejectedError
+	return errors.As(err, &r) ||
+		errors.Is(err, ErrInvalidProvider) ||
+		errors.Is(err, ErrInvalidGroup) ||
+		errors.Is(err, ErrInvalidLamp) ||
+		errors.Is(err, ErrInvalidColor) ||
+		errors.Is(err, ErrInvalidSelector) ||
+		errors.Is(err, ErrNoMatch)
+}
+
+// NewResult creates a result for the change from the error returned when
+// applying it, which may be nil.
+func NewResult(c *Change, err error) *Result {
+	r := &Result{
+		ProviderID: c.ProviderID,
+		GroupID:    c.GroupID,
+		LampID:     c.LampID,
+		Selector:   c.Selector,
+		Status:     ResultApplied,
+	}
+	if err != nil {
+		r.Error = err.Error()
+		if IsRejection(err) {
+			r.Status = ResultRejected
+		} else {
+			r.Status = ResultFailed
+		}
+	}
+	return r
+}
+
+// NewResults creates a result for each change using the same error.
+func NewResults(changes []*Change, err error) []*Result {
+	results := []*Result{}
+	for _, c := range changes {
+		results = append(results, NewResult(c, err))
+	}
+	return results
+}
+
+// ApplyEach invokes fn for every change, continuing after errors, and returns
+// the result for each.
+func ApplyEach(changes []*Change, fn func(c *Change) error) []*Result {
+	results := []*Result{}
+	for _, c := range changes {
+		results = append(results, NewResult(c, fn(c)))
+	}
+	return results
+}
+
+// Err returns the first error in the results or nil if all changes were
+// applied.
+func Err(results []*Result) error {
+	for _, r := range results {
+		if r.Status != ResultApplied {
+			if r.ProviderID != "" {
+				return fmt.Errorf("%s/%s/%s: %s", r.ProviderID, r.GroupID, r.LampID, r.Error)
+			}
+			return fmt.Errorf("%s/%s: %s", r.GroupID, r.LampID, r.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	registry_db "github.com/lampctl/lampctl/registry/db"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidScene = errors.New("invalid scene specified")
+
+// Scene is a named set of changes that can be applied together. Each change
+// must specify a ProviderID or a selector.
+type Scene struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Changes []*Change `json:"changes"`
+}
+
+func newScene(v *registry_db.Scene) (*Scene, error) {
+	s := &Scene{
+		ID:   v.ID,
+		Name: v.Name,
+	}
+	if err := json.Unmarshal([]byte(v.Changes), &s.Changes); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Scenes returns all stored scenes.
+func (r *Registry) Scenes() ([]*Scene, error) {
+	rows := []*registry_db.Scene{}
+	if err := r.db.Order("name").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	scenes := []*Scene{}
+	for _, v := range rows {
+		s, err := newScene(v)
+		if err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, s)
+	}
+	return scenes, nil
+}
+
+// Scene retrieves the scene with the specified ID.
+func (r *Registry) Scene(id int64) (*Scene, error) {
+	v := &registry_db.Scene{}
+	if err := r.db.First(v, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidScene
+		}
+		return nil, err
+	}
+	return newScene(v)
+}
+
+// SaveScene stores the scene in the database, creating it if the ID is zero.
+func (r *Registry) SaveScene(s *Scene) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return Rejectf("scene name is required")
+	}
+	if s.Changes == nil {
+		s.Changes = []*Change{}
+	}
+	for _, c := range s.Changes {
+		if c.Selector == nil && c.ProviderID == "" {
+			return Rejectf("each change requires a provider or selector")
+		}
+	}
+	b, err := json.Marshal(s.Changes)
+	if err != nil {
+		return err
+	}
+	v := &registry_db.Scene{
+		ID:      s.ID,
+		Name:    s.Name,
+		Changes: string(b),
+	}
+	if err := r.db.Save(v).Error; err != nil {
+		return err
+	}
+	s.ID = v.ID
+	return nil
+}
+
+// DeleteScene removes the scene with the specified ID. Lamps that were set
+// to power on with the scene fall back to their last state.
+func (r *Registry) DeleteScene(id int64) error {
+	return r.db.Delete(&registry_db.Scene{}, id).Error
+}
+
+// ApplyScene applies all of the changes in the scene.
+func (r *Registry) ApplyScene(ctx context.Context, id int64) ([]*Result, error) {
+	s, err := r.Scene(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.ApplyChanges(ctx, s.Changes), nil
+}
@@ -0,0 +1,265 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	selectorTag      = "tag"
+	selectorProvider = "provider"
+	selectorGroup    = "group"
+	selectorLamp     = "lamp"
+)
+
+var (
+	ErrInvalidSelector = errors.New("invalid selector")
+	ErrNoMatch         = errors.New("selector did not match any lamps")
+)
+
+// selectorTarget contains everything a selector can match against.
+type selectorTarget struct {
+	ProviderID string
+	GroupID    string
+	GroupName  string
+	LampID     string
+	LampName   string
+	Tags       []string
+}
+
+type selectorNode interface {
+	match(l *selectorTarget) bool
+}
+
+type selectorAnd struct {
+	left, right selectorNode
+}
+
+func (n *selectorAnd) match(l *selectorTarget) bool {
+	return n.left.match(l) && n.right.match(l)
+}
+
+type selectorOr struct {
+	left, right selectorNode
+}
+
+func (n *selectorOr) match(l *selectorTarget) bool {
+	return n.left.match(l) || n.right.match(l)
+}
+
+type selectorNot struct {
+	node selectorNode
+}
+
+func (n *selectorNot) match(l *selectorTarget) bool {
+	return !n.node.match(l)
+}
+
+type selectorTerm struct {
+	key   string
+	value string
+}
+
+func (n *selectorTerm) match(l *selectorTarget) bool {
+	switch n.key {
+	case selectorTag:
+		for _, t := range l.Tags {
+			if strings.EqualFold(t, n.value) {
+				return true
+			}
+		}
+		return false
+	case selectorProvider:
+		return l.ProviderID == n.value
+	case selectorGroup:
+		return l.GroupID == n.value || strings.EqualFold(l.GroupName, n.value)
+	default:
+		return l.LampID == n.value || strings.EqualFold(l.LampName, n.value)
+	}
+}
+
+// tokenizeSelector splits the expression into parentheses, operators, and
+// terms. Values containing spaces may be quoted, as in group:"front porch".
+func tokenizeSelector(s string) ([]string, error) {
+	var (
+		tokens  = []string{}
+		current strings.Builder
+		quoted  bool
+	)
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+			current.WriteRune(r)
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: unterminated quote", ErrInvalidSelector)
+	}
+	flush()
+	return tokens, nil
+}
+
+type selectorParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selectorParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *selectorParser) accept(op string) bool {
+	if strings.EqualFold(p.peek(), op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) parseOr() (selectorNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &selectorOr{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd also treats adjacent terms as being joined by AND.
+func (p *selectorParser) parseAnd() (selectorNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		next := p.peek()
+		if next == "" || next == ")" || strings.EqualFold(next, "OR") {
+			return left, nil
+		}
+		p.accept("AND")
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &selectorAnd{left, right}
+	}
+}
+
+func (p *selectorParser) parseNot() (selectorNode, error) {
+	if p.accept("NOT") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &selectorNot{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *selectorParser) parsePrimary() (selectorNode, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrInvalidSelector)
+	case token == "(":
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("%w: missing \")\"", ErrInvalidSelector)
+		}
+		return n, nil
+	}
+	p.pos++
+	key, value, ok := strings.Cut(token, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("%w: expected key:value, got %q", ErrInvalidSelector, token)
+	}
+	key = strings.ToLower(key)
+	switch key {
+	case selectorTag, selectorProvider, selectorGroup, selectorLamp:
+	default:
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidSelector, key)
+	}
+	return &selectorTerm{key: key, value: value}, nil
+}
+
+// Selector matches lamps using an expression such as
+// "tag:outdoor AND provider:ws2811" or "group:porch". Terms may be combined
+// with AND, OR, NOT, and parentheses. Groups and lamps are matched by either
+// their ID or their name.
+type Selector struct {
+	source string
+	root   selectorNode
+}
+
+// ParseSelector parses the expression into a Selector.
+func ParseSelector(s string) (*Selector, error) {
+	tokens, err := tokenizeSelector(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &selectorParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidSelector, p.peek())
+	}
+	return &Selector{
+		source: s,
+		root:   root,
+	}, nil
+}
+
+func (s *Selector) String() string {
+	return s.source
+}
+
+// MarshalJSON encodes the selector as its original expression.
+func (s *Selector) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.source)
+}
+
+// UnmarshalJSON parses the selector from a string.
+func (s *Selector) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p, err := ParseSelector(v)
+	if err != nil {
+		return err
+	}
+	*s = *p
+	return nil
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"context"
+)
+
+const (
+	SourceREST       = "rest"
+	SourceWebsocket  = "websocket"
+	SourceSequencer  = "sequencer"
+	SourceScheduler  = "scheduler"
+	SourceEffect     = "effect"
+	SourceAutomation = "automation"
+	SourcePresence   = "presence"
+	SourceExternal   = "external"
+	SourceSystem     = "system"
+)
+
+// Source identifies what caused a change so that it can be attributed in
+// the history. ID is specific to the type, such as the address of a client
+// or the ID of an effect. Session groups changes from the same client for
+// undo and defaults to the type and ID.
+type Source struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+type sourceKey struct{}
+
+// WithSource returns a context that attributes changes applied with it to
+// the specified source.
+func WithSource(ctx context.Context, sourceType, id string) context.Context {
+	return WithSession(ctx, sourceType, id, "")
+}
+
+// WithSession is like WithSource but also specifies the session that the
+// changes belong to.
+func WithSession(ctx context.Context, sourceType, id, session string) context.Context {
+	if session == "" {
+		session = sourceType + ":" + id
+	}
+	return context.WithValue(ctx, sourceKey{}, &Source{
+		Type:    sourceType,
+		ID:      id,
+		Session: session,
+	})
+}
+
+// SourceFrom returns the source attached to the context, which is assumed to
+// be the system itself if there is none.
+func SourceFrom(ctx context.Context) *Source {
+	if v, ok := ctx.Value(sourceKey{}).(*Source); ok {
+		return v
+	}
+	return &Source{Type: SourceSystem, Session: SourceSystem + ":"}
+}
@@ -0,0 +1,220 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	registry_db "github.com/lampctl/lampctl/registry/db"
+	"github.com/rs/zerolog"
+)
+
+// stateFlushInterval is the amount of time to wait after a lamp changes
+// before writing its state to the database, so that rapid changes (such as
+// those from effects or the sequencer) result in a single write.
+const stateFlushInterval = 5 * time.Second
+
+// frame determines whether changes made with the context are frames of an
+// animation, which effects and the sequencer apply many times a second.
+// Frames are not stored as the desired state of lamps and their events are
+// coalesced.
+func frame(ctx context.Context) bool {
+	switch SourceFrom(ctx).Type {
+	case SourceSequencer, SourceEffect:
+		return true
+	}
+	return false
+}
+
+// stateStore keeps track of the last state applied to each lamp and
+// periodically writes any that changed to the database. Frames are kept
+// separately, in memory only, so that the stored state is the last one
+// that was chosen rather than part of an animation.
+type stateStore struct {
+	mutex      sync.Mutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	states     map[lampKey]*registry_db.State
+	dirty      map[lampKey]*registry_db.State
+	frames     map[lampKey]*registry_db.State
+	applying   map[lampKey]int
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func newStateStore(logger zerolog.Logger, conn *db.Conn) (*stateStore, error) {
+	if err := conn.AutoMigrate(&registry_db.State{}); err != nil {
+		return nil, err
+	}
+	states := []*registry_db.State{}
+	if err := conn.Find(&states).Error; err != nil {
+		return nil, err
+	}
+	s := &stateStore{
+		logger:     logger,
+		db:         conn,
+		states:     make(map[lampKey]*registry_db.State),
+		dirty:      make(map[lampKey]*registry_db.State),
+		frames:     make(map[lampKey]*registry_db.State),
+		applying:   make(map[lampKey]int),
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	for _, v := range states {
+		s.states[lampKey{v.ProviderID, v.GroupID, v.LampID}] = v
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *stateStore) run() {
+	defer close(s.closedChan)
+	defer s.flush()
+	for {
+		select {
+		case <-s.wakeChan:
+		case <-s.closeChan:
+			return
+		}
+		select {
+		case <-time.After(stateFlushInterval):
+		case <-s.closeChan:
+			return
+		}
+		s.flush()
+	}
+}
+
+// flush writes all of the states that changed since the last flush.
+func (s *stateStore) flush() {
+	s.mutex.Lock()
+	dirty := s.dirty
+	s.dirty = make(map[lampKey]*registry_db.State)
+	s.mutex.Unlock()
+	if len(dirty) == 0 {
+		return
+	}
+	if err := s.db.Transaction(func(conn *db.Conn) error {
+		for _, v := range dirty {
+			if err := conn.Save(v).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		s.logger.Error().Msg(err.Error())
+	}
+}
+
+// newState returns the state that the change sets the lamp to. If the change
+// does not specify a color, the previous one is kept. The mutex must be held.
+func (s *stateStore) newState(k lampKey, c *Change) *registry_db.State {
+	v := &registry_db.State{
+		ProviderID: k.ProviderID,
+		GroupID:    c.GroupID,
+		LampID:     c.LampID,
+		State:      c.State,
+		Brightness: c.Brightness,
+		UpdatedAt:  time.Now(),
+	}
+	if c.Color != nil {
+		v.Color = c.Color.String()
+	} else if old, ok := s.current(k); ok {
+		v.Color = old.Color
+	}
+	return v
+}
+
+// record remembers the state that the change sets the lamp to, replacing
+// any frame.
+func (s *stateStore) record(providerID string, c *Change) {
+	k := lampKey{providerID, c.GroupID, c.LampID}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v := s.newState(k, c)
+	delete(s.frames, k)
+	s.states[k] = v
+	s.dirty[k] = v
+	select {
+	case s.wakeChan <- nil:
+	default:
+	}
+}
+
+// recordFrame remembers the state that the frame sets the lamp to without
+// changing the stored state.
+func (s *stateStore) recordFrame(providerID string, c *Change) {
+	k := lampKey{providerID, c.GroupID, c.LampID}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.frames[k] = s.newState(k, c)
+}
+
+// get returns the last state recorded for the lamp, ignoring frames.
+func (s *stateStore) get(k lampKey) (*registry_db.State, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.states[k]
+	return v, ok
+}
+
+// live returns the state that the lamp is expected to be in, which is the
+// last frame if it was changed by one since its state was last recorded.
+func (s *stateStore) live(k lampKey) (*registry_db.State, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.current(k)
+}
+
+// current is live without taking the mutex, which must be held.
+func (s *stateStore) current(k lampKey) (*registry_db.State, bool) {
+	if v, ok := s.frames[k]; ok {
+		return v, true
+	}
+	v, ok := s.states[k]
+	return v, ok
+}
+
+// begin marks the lamps as being changed until the returned function is
+// called, which must happen after their new states are recorded.
+func (s *stateStore) begin(providerID string, changes []*Change) func() {
+	keys := []lampKey{}
+	for _, c := range changes {
+		keys = append(keys, lampKey{providerID, c.GroupID, c.LampID})
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, k := range keys {
+		s.applying[k]++
+	}
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, k := range keys {
+			if s.applying[k]--; s.applying[k] == 0 {
+				delete(s.applying, k)
+			}
+		}
+	}
+}
+
+// changedSince determines whether the lamp is being changed or its state was
+// recorded after t.
+func (s *stateStore) changedSince(k lampKey, t time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.applying[k] > 0 {
+		return true
+	}
+	v, ok := s.current(k)
+	return ok && v.UpdatedAt.After(t)
+}
+
+// close writes any pending changes and stops the goroutine.
+func (s *stateStore) close() {
+	close(s.closeChan)
+	<-s.closedChan
+}
@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Factory creates and initializes a provider.
+type Factory func() (Provider, error)
+
+// Supervised wraps a provider that may fail to initialize (for example, due
+// to missing hardware). If the factory fails, the provider is reported as
+// degraded and initialization is retried in the background with exponential
+// backoff. Until then, no groups or lamps are reported and changes fail.
+type Supervised struct {
+	mutex      sync.RWMutex
+	id         string
+	name       string
+	factory    Factory
+	provider   Provider
+	health     *Health
+	onReady    func()
+	closeChan  chan any
+	closedChan chan any
+}
+
+// NewSupervised creates a new supervised provider, making the first attempt
+// to initialize it immediately.
+func NewSupervised(id, name string, factory Factory) *Supervised {
+	s := &Supervised{
+		id:         id,
+		name:       name,
+		factory:    factory,
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	if s.attempt() {
+		close(s.closedChan)
+	} else {
+		go s.run()
+	}
+	return s
+}
+
+// attempt tries to create the provider, updating the health accordingly.
+func (s *Supervised) attempt() bool {
+	p, err := s.factory()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if err != nil {
+		since := now
+		if s.health != nil {
+			since = s.health.Since
+		}
+		s.health = &Health{
+			Status: HealthDegraded,
+			Error:  err.Error(),
+			Since:  since,
+		}
+		return false
+	}
+	s.provider = p
+	s.health = &Health{
+		Status: HealthOK,
+		Since:  now,
+	}
+	return true
+}
+
+func (s *Supervised) run() {
+	defer close(s.closedChan)
+	retry := NextRetryInterval(0)
+	for {
+		func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			next := time.Now().Add(retry)
+			s.health.NextRetry = &next
+		}()
+		select {
+		case <-time.After(retry):
+		case <-s.closeChan:
+			return
+		}
+		retry = NextRetryInterval(retry)
+		if s.attempt() {
+			s.mutex.RLock()
+			onReady := s.onReady
+			s.mutex.RUnlock()
+			if onReady != nil {
+				onReady()
+			}
+			return
+		}
+	}
+}
+
+func (s *Supervised) get() (Provider, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.provider == nil {
+		return nil, ErrProviderUnavailable
+	}
+	return s.provider, nil
+}
+
+// setReadyHandler sets a function to be invoked when the provider becomes
+// available after initially failing.
+func (s *Supervised) setReadyHandler(fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onReady = fn
+}
+
+// Health returns the health of the wrapped provider if it was created and
+// otherwise the error that prevented it from being created.
+func (s *Supervised) Health() *Health {
+	if p, err := s.get(); err == nil {
+		if v, ok := p.(HealthReporter); ok {
+			return v.Health()
+		}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	h := *s.health
+	return &h
+}
+
+func (s *Supervised) ID() string {
+	return s.id
+}
+
+func (s *Supervised) Name() string {
+	return s.name
+}
+
+// Init adds the routes of the wrapped provider. Nothing is added while it is
+// unavailable; watchers are notified once it becomes available, which
+// rebuilds the router and adds them. Providers that manage several devices,
+// such as Hue bridges, should therefore report unreachable devices through
+// their health rather than failing to be created.
+func (s *Supervised) Init(api *gin.RouterGroup) error {
+	p, err := s.get()
+	if err != nil {
+		return nil
+	}
+	return p.Init(api)
+}
+
+func (s *Supervised) Close() {
+	select {
+	case <-s.closedChan:
+	default:
+		close(s.closeChan)
+		<-s.closedChan
+	}
+	if p, err := s.get(); err == nil {
+		p.Close()
+	}
+}
+
+func (s *Supervised) Groups() []*Group {
+	p, err := s.get()
+	if err != nil {
+		return []*Group{}
+	}
+	return p.Groups()
+}
+
+func (s *Supervised) Lamps() []*Lamp {
+	p, err := s.get()
+	if err != nil {
+		return []*Lamp{}
+	}
+	return p.Lamps()
+}
+
+func (s *Supervised) Apply(ctx context.Context, changes []*Change) []*Result {
+	p, err := s.get()
+	if err != nil {
+		return NewResults(changes, err)
+	}
+	return p.Apply(ctx, changes)
+}
+
+func (s *Supervised) ApplyToAll(ctx context.Context, change *Change) error {
+	p, err := s.get()
+	if err != nil {
+		return err
+	}
+	return p.ApplyToAll(ctx, change)
+}
+
+// Refresh refreshes the wrapped provider if it supports doing so.
+func (s *Supervised) Refresh(ctx context.Context) error {
+	p, err := s.get()
+	if err != nil {
+		return nil
+	}
+	if v, ok := p.(Refresher); ok {
+		return v.Refresh(ctx)
+	}
+	return nil
+}
+
+// NativeTransitions reports whether the wrapped provider can fade on its own.
+func (s *Supervised) NativeTransitions() bool {
+	p, err := s.get()
+	return err == nil && hasNativeTransitions(p)
+}
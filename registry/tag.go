@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"sort"
+	"strings"
+
+	registry_db "github.com/lampctl/lampctl/registry/db"
+)
+
+// loadTags reads all of the tags from the database.
+func (r *Registry) loadTags() error {
+	if err := r.db.AutoMigrate(&registry_db.Tag{}); err != nil {
+		return err
+	}
+	tags := []*registry_db.Tag{}
+	if err := r.db.Find(&tags).Error; err != nil {
+		return err
+	}
+	for _, t := range tags {
+		k := lampKey{t.ProviderID, t.GroupID, t.LampID}
+		r.tags[k] = append(r.tags[k], t.Name)
+	}
+	return nil
+}
+
+// Tags returns every tag that has been assigned to a lamp.
+func (r *Registry) Tags() []*registry_db.Tag {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tags := []*registry_db.Tag{}
+	for k, v := range r.tags {
+		for _, t := range v {
+			tags = append(tags, &registry_db.Tag{
+				ProviderID: k.ProviderID,
+				GroupID:    k.GroupID,
+				LampID:     k.LampID,
+				Name:       t,
+			})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.ProviderID != b.ProviderID {
+			return a.ProviderID < b.ProviderID
+		}
+		if a.GroupID != b.GroupID {
+			return a.GroupID < b.GroupID
+		}
+		return a.LampID < b.LampID
+	})
+	return tags
+}
+
+// AddTag assigns a tag to the specified lamp. Tags are case-insensitive and
+// stored in lowercase.
+func (r *Registry) AddTag(t *registry_db.Tag) error {
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	if t.Name == "" || strings.ContainsAny(t.Name, " \t\n\"()") {
+		return Rejectf("invalid tag %q", t.Name)
+	}
+	if t.ProviderID == "" || t.GroupID == "" || t.LampID == "" {
+		return Rejectf("provider, group, and lamp are required")
+	}
+	if err := r.db.Save(t).Error; err != nil {
+		return err
+	}
+	k := lampKey{t.ProviderID, t.GroupID, t.LampID}
+	added := func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for _, v := range r.tags[k] {
+			if v == t.Name {
+				return false
+			}
+		}
+		r.tags[k] = append(r.tags[k], t.Name)
+		return true
+	}()
+	if added {
+		r.publishKey(k)
+	}
+	return nil
+}
+
+// RemoveTag removes a tag from the specified lamp.
+func (r *Registry) RemoveTag(t *registry_db.Tag) error {
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	if t.Name == "" {
+		return Rejectf("invalid tag %q", t.Name)
+	}
+	if t.ProviderID == "" || t.GroupID == "" || t.LampID == "" {
+		return Rejectf("provider, group, and lamp are required")
+	}
+	if err := r.db.
+		Where(
+			"provider_id = ? AND group_id = ? AND lamp_id = ? AND name = ?",
+			t.ProviderID, t.GroupID, t.LampID, t.Name,
+		).
+		Delete(&registry_db.Tag{}).Error; err != nil {
+		return err
+	}
+	k := lampKey{t.ProviderID, t.GroupID, t.LampID}
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		tags := []string{}
+		for _, v := range r.tags[k] {
+			if v != t.Name {
+				tags = append(tags, v)
+			}
+		}
+		if len(tags) == 0 {
+			delete(r.tags, k)
+		} else {
+			r.tags[k] = tags
+		}
+	}()
+	r.publishKey(k)
+	return nil
+}
+
+// lampTags returns a copy of the tags for the lamp.
+func (r *Registry) lampTags(k lampKey) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tags := append([]string{}, r.tags[k]...)
+	sort.Strings(tags)
+	return tags
+}
+
+// Select returns every lamp matched by the selector. If providerID is not
+// empty, only lamps in that provider are considered.
+func (r *Registry) Select(s *Selector, providerID string) []*Target {
+	targets := []*Target{}
+	for _, p := range r.Providers() {
+		if providerID != "" && p.ID() != providerID {
+			continue
+		}
+		groupNames := map[string]string{}
+		for _, g := range p.Groups() {
+			groupNames[g.ID] = g.Name
+		}
+		for _, l := range r.Lamps(p) {
+			v := &selectorTarget{
+				ProviderID: p.ID(),
+				GroupID:    l.GroupID,
+				GroupName:  groupNames[l.GroupID],
+				LampID:     l.ID,
+				LampName:   l.Name,
+				Tags:       l.Tags,
+			}
+			if s.root.match(v) {
+				targets = append(targets, &Target{
+					ProviderID: p.ID(),
+					GroupID:    l.GroupID,
+					LampID:     l.ID,
+				})
+			}
+		}
+	}
+	return targets
+}
+
+// expand replaces each change that has a selector with a copy for every lamp
+// it matches. Changes whose selector matches nothing are returned as
+// rejected results.
+func (r *Registry) expand(changes []*Change, providerID string) ([]*Change, []*Result) {
+	var (
+		expanded = []*Change{}
+		rejected = []*Result{}
+	)
+	for _, c := range changes {
+		if c.Selector == nil {
+			expanded = append(expanded, c)
+			continue
+		}
+		targets := r.Select(c.Selector, providerID)
+		if len(targets) == 0 {
+			rejected = append(rejected, NewResult(c, ErrNoMatch))
+			continue
+		}
+		for _, t := range targets {
+			v := *c
+			v.ProviderID = t.ProviderID
+			v.GroupID = t.GroupID
+			v.LampID = t.LampID
+			v.Selector = nil
+			expanded = append(expanded, &v)
+		}
+	}
+	return expanded, rejected
+}
@@ -0,0 +1,424 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	registry_db "github.com/lampctl/lampctl/registry/db"
+	"github.com/lampctl/lampctl/util"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TimerRevert = "revert"
+	TimerMaxOn  = "max_on"
+
+	// timerRetryInterval is the amount of time to wait before applying a
+	// timer again after the provider failed to apply it
+	timerRetryInterval = time.Minute
+)
+
+var ErrInvalidTimer = errors.New("invalid timer specified")
+
+// Timer is a change that will be applied to a lamp at a later time, either
+// to revert a change with RevertAfter or to turn off a lamp that was on for
+// longer than its maximum on-time.
+type Timer struct {
+	ProviderID string    `json:"provider_id"`
+	GroupID    string    `json:"group_id"`
+	LampID     string    `json:"lamp_id"`
+	Reason     string    `json:"reason"`
+	At         time.Time `json:"at"`
+	Change     *Change   `json:"change"`
+}
+
+// loadTimers reads the pending timers and maximum on-times from the
+// database.
+func (r *Registry) loadTimers() error {
+	if err := r.db.AutoMigrate(
+		&registry_db.Timer{},
+		&registry_db.MaxOn{},
+	); err != nil {
+		return err
+	}
+	timers := []*registry_db.Timer{}
+	if err := r.db.Find(&timers).Error; err != nil {
+		return err
+	}
+	for _, v := range timers {
+		c := &Change{}
+		if err := json.Unmarshal([]byte(v.Change), c); err != nil {
+			r.logger.Error().Str("provider", v.ProviderID).Msg(err.Error())
+			continue
+		}
+		r.timers[lampKey{v.ProviderID, v.GroupID, v.LampID}] = &Timer{
+			ProviderID: v.ProviderID,
+			GroupID:    v.GroupID,
+			LampID:     v.LampID,
+			Reason:     v.Reason,
+			At:         v.At,
+			Change:     c,
+		}
+	}
+	maxOn := []*registry_db.MaxOn{}
+	if err := r.db.Find(&maxOn).Error; err != nil {
+		return err
+	}
+	for _, v := range maxOn {
+		r.maxOn[lampKey{v.ProviderID, v.GroupID, v.LampID}] = v
+	}
+	return nil
+}
+
+// timed determines whether changes from the source cancel pending timers
+// and start them for lamps with a maximum on-time. As with undoable, only
+// the sources listed are included; unlike undo, this covers the scheduler and
+// automation as well as lamps switched on externally, such as with a wall
+// switch, since those are the lamps most likely to be left on. Frames from
+// the sequencer and effects, the presence simulation, and changes made by
+// the system itself, such as those applied by timers, are excluded.
+func timed(ctx context.Context) bool {
+	switch SourceFrom(ctx).Type {
+	case SourceREST, SourceWebsocket, SourceScheduler, SourceAutomation, SourceExternal:
+		return true
+	}
+	return false
+}
+
+// revertChanges determines the current state of each lamp that is about to
+// be changed with RevertAfter so that it can be restored later.
+func (r *Registry) revertChanges(p Provider, changes []*Change) map[lampKey]*Change {
+	reverted := []*Change{}
+	for _, c := range changes {
+		if c.RevertAfter > 0 {
+			reverted = append(reverted, c)
+		}
+	}
+	if len(reverted) == 0 {
+		return nil
+	}
+	return r.beforeChanges(p, reverted)
+}
+
+// maxOnFor returns the maximum on-time for the lamp, falling back to the one
+// for its group.
+func (r *Registry) maxOnFor(k lampKey) time.Duration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	v, ok := r.maxOn[k]
+	if !ok {
+		v, ok = r.maxOn[lampKey{k.ProviderID, k.GroupID, ""}]
+	}
+	if !ok {
+		return 0
+	}
+	return time.Duration(v.Duration) * time.Millisecond
+}
+
+// updateTimers starts, replaces, and cancels timers for changes that were
+// applied. Changes with RevertAfter always start a timer; if one is already
+// pending to revert the lamp, it keeps the state it reverts to so that the
+// lamp returns to how it was before the first of them. Otherwise, a change
+// from a timed source cancels any pending timer and, if it turns the lamp
+// on, starts one for the lamp's maximum on-time.
+func (r *Registry) updateTimers(
+	ctx context.Context,
+	providerID string,
+	changes []*Change,
+	reverts map[lampKey]*Change,
+) {
+	var (
+		now     = time.Now()
+		isTimed = timed(ctx)
+		changed = false
+	)
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
+	for _, c := range changes {
+		var (
+			k = lampKey{providerID, c.GroupID, c.LampID}
+			t *Timer
+		)
+		switch {
+		case c.RevertAfter > 0:
+			var v Change
+			if old, ok := r.timers[k]; ok && old.Reason == TimerRevert {
+				v = *old.Change
+			} else if reverts[k] != nil {
+				v = *reverts[k]
+				v.ProviderID = ""
+			} else {
+				continue
+			}
+			t = &Timer{
+				Reason: TimerRevert,
+				At:     now.Add(time.Duration(c.RevertAfter) * time.Millisecond),
+				Change: &v,
+			}
+		case !isTimed:
+			continue
+		case c.State:
+			d := r.maxOnFor(k)
+			if d <= 0 {
+				break
+			}
+			v := *c
+			v.ProviderID = ""
+			v.Selector = nil
+			v.State = false
+			v.Duration = 0
+			t = &Timer{
+				Reason: TimerMaxOn,
+				At:     now.Add(d),
+				Change: &v,
+			}
+		}
+		if t == nil {
+			if _, ok := r.timers[k]; ok {
+				r.deleteTimer(k)
+				changed = true
+			}
+			continue
+		}
+		t.ProviderID, t.GroupID, t.LampID = k.ProviderID, k.GroupID, k.LampID
+		if err := r.saveTimer(t); err != nil {
+			r.logger.Error().Str("provider", providerID).Msg(err.Error())
+			continue
+		}
+		changed = true
+	}
+	if changed {
+		select {
+		case r.timerWakeChan <- nil:
+		default:
+		}
+	}
+}
+
+// saveTimer stores the timer, replacing any other timer for the lamp. The
+// timer mutex must be held.
+func (r *Registry) saveTimer(t *Timer) error {
+	b, err := json.Marshal(t.Change)
+	if err != nil {
+		return err
+	}
+	if err := r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&registry_db.Timer{
+		ProviderID: t.ProviderID,
+		GroupID:    t.GroupID,
+		LampID:     t.LampID,
+		Reason:     t.Reason,
+		At:         t.At,
+		Change:     string(b),
+	}).Error; err != nil {
+		return err
+	}
+	r.timers[lampKey{t.ProviderID, t.GroupID, t.LampID}] = t
+	return nil
+}
+
+// deleteTimer removes the timer for the lamp. The timer mutex must be held.
+func (r *Registry) deleteTimer(k lampKey) {
+	delete(r.timers, k)
+	if err := r.db.
+		Where("provider_id = ? AND group_id = ? AND lamp_id = ?", k.ProviderID, k.GroupID, k.LampID).
+		Delete(&registry_db.Timer{}).Error; err != nil {
+		r.logger.Error().Str("provider", k.ProviderID).Msg(err.Error())
+	}
+}
+
+// timersReady allows timers for the provider to be applied once the
+// power-on behavior of its lamps has been applied.
+func (r *Registry) timersReady(providerID string, ready bool) {
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
+	if ready {
+		r.timerProviders[providerID] = true
+	} else {
+		delete(r.timerProviders, providerID)
+	}
+	select {
+	case r.timerWakeChan <- nil:
+	default:
+	}
+}
+
+func (r *Registry) runTimers() {
+	defer close(r.timerClosedChan)
+	for {
+		t := time.NewTimer(r.applyTimers())
+		select {
+		case <-t.C:
+		case <-r.timerWakeChan:
+			t.Stop()
+		case <-r.timerCloseChan:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// applyTimers applies every timer that is due and returns the amount of
+// time to wait before the next one.
+func (r *Registry) applyTimers() time.Duration {
+	var (
+		now  = time.Now()
+		next = now.Add(util.MaxSleep)
+		due  = map[string][]*Timer{}
+	)
+	r.timerMutex.Lock()
+	for _, t := range r.timers {
+		if !r.timerProviders[t.ProviderID] {
+			continue
+		}
+		if t.At.After(now) {
+			if t.At.Before(next) {
+				next = t.At
+			}
+			continue
+		}
+		due[t.ProviderID] = append(due[t.ProviderID], t)
+	}
+	r.timerMutex.Unlock()
+	if len(due) == 0 {
+		return next.Sub(now)
+	}
+	for id, timers := range due {
+		r.applyProviderTimers(id, timers)
+	}
+	return 0
+}
+
+// applyProviderTimers applies the timers for lamps in a single provider.
+// Timers that fail are tried again later and those that are rejected (for
+// example, because the lamp no longer exists) are discarded.
+func (r *Registry) applyProviderTimers(providerID string, timers []*Timer) {
+	changes := []*Change{}
+	for _, t := range timers {
+		c := *t.Change
+		changes = append(changes, &c)
+	}
+	ctx := WithSource(context.Background(), SourceSystem, "timer")
+	results, err := r.Apply(ctx, providerID, changes)
+	if err != nil {
+		results = NewResults(changes, err)
+	}
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
+	for i, t := range timers {
+		k := lampKey{t.ProviderID, t.GroupID, t.LampID}
+
+		// The timer may have been replaced or cancelled in the meantime
+		if r.timers[k] != t {
+			continue
+		}
+		l := r.logger.With().
+			Str("provider", t.ProviderID).
+			Str("group", t.GroupID).
+			Str("lamp", t.LampID).
+			Str("reason", t.Reason).
+			Logger()
+		switch results[i].Status {
+		case ResultApplied:
+			l.Info().Msg("timer applied")
+		case ResultFailed:
+			l.Warn().Msg(results[i].Error)
+			t.At = time.Now().Add(timerRetryInterval)
+			if err := r.saveTimer(t); err != nil {
+				l.Error().Msg(err.Error())
+			}
+			continue
+		default:
+			l.Error().Msg(results[i].Error)
+		}
+		r.deleteTimer(k)
+	}
+}
+
+// Timers returns every pending timer in the order they are due.
+func (r *Registry) Timers() []*Timer {
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
+	timers := []*Timer{}
+	for _, v := range r.timers {
+		t := *v
+		c := *v.Change
+		t.Change = &c
+		timers = append(timers, &t)
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		return timers[i].At.Before(timers[j].At)
+	})
+	return timers
+}
+
+// CancelTimer removes the pending timer for a lamp without applying it.
+func (r *Registry) CancelTimer(providerID, groupID, lampID string) error {
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
+	k := lampKey{providerID, groupID, lampID}
+	if _, ok := r.timers[k]; !ok {
+		return ErrInvalidTimer
+	}
+	r.deleteTimer(k)
+	return nil
+}
+
+// MaxOn returns the maximum on-time of every lamp and group that has one.
+func (r *Registry) MaxOn() []*registry_db.MaxOn {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	maxOn := []*registry_db.MaxOn{}
+	for _, v := range r.maxOn {
+		m := *v
+		maxOn = append(maxOn, &m)
+	}
+	sort.Slice(maxOn, func(i, j int) bool {
+		a, b := maxOn[i], maxOn[j]
+		if a.ProviderID != b.ProviderID {
+			return a.ProviderID < b.ProviderID
+		}
+		if a.GroupID != b.GroupID {
+			return a.GroupID < b.GroupID
+		}
+		return a.LampID < b.LampID
+	})
+	return maxOn
+}
+
+// SetMaxOn stores the maximum on-time for a lamp or, if LampID is empty, for
+// every lamp in a group. It applies the next time a lamp is turned on.
+func (r *Registry) SetMaxOn(v *registry_db.MaxOn) error {
+	if v.ProviderID == "" || v.GroupID == "" {
+		return Rejectf("provider and group are required")
+	}
+	if v.Duration <= 0 {
+		return Rejectf("duration must be greater than zero")
+	}
+	// The lamp is part of the primary key even when empty, so an upsert is
+	// used in place of Save, which would always insert
+	if err := r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(v).Error; err != nil {
+		return err
+	}
+	m := *v
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maxOn[lampKey{v.ProviderID, v.GroupID, v.LampID}] = &m
+	return nil
+}
+
+// DeleteMaxOn removes the maximum on-time for a lamp or group. Pending
+// timers are not affected.
+func (r *Registry) DeleteMaxOn(providerID, groupID, lampID string) error {
+	if err := r.db.
+		Where("provider_id = ? AND group_id = ? AND lamp_id = ?", providerID, groupID, lampID).
+		Delete(&registry_db.MaxOn{}).Error; err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.maxOn, lampKey{providerID, groupID, lampID})
+	return nil
+}
@@ -0,0 +1,279 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/rs/zerolog"
+)
+
+// minBrightness is used for frames that must keep a lamp lit while it is
+// fading in or out so that it does not appear to be switched off.
+const minBrightness = 0.01
+
+var white = colorful.Color{R: 1, G: 1, B: 1}
+
+type lampKey struct {
+	ProviderID string
+	GroupID    string
+	LampID     string
+}
+
+type lampState struct {
+	Brightness float64
+	Color      colorful.Color
+}
+
+func newLampState(c *Change) *lampState {
+	s := &lampState{
+		Color: white,
+	}
+	if c.State {
+		s.Brightness = c.Brightness
+		if s.Brightness == 0 {
+			s.Brightness = 1
+		}
+	}
+	if c.Color != nil {
+		s.Color = c.Color.Color
+	}
+	return s
+}
+
+type transition struct {
+	provider Provider
+	key      lampKey
+	from     *lampState
+	to       *lampState
+	start    time.Time
+	duration time.Duration
+	final    *Change
+}
+
+// at determines the state of the lamp at the specified point in time and
+// whether the transition has completed.
+func (t *transition) at(now time.Time) (*lampState, bool) {
+	p := float64(now.Sub(t.start)) / float64(t.duration)
+	if p >= 1 {
+		return t.to, true
+	}
+	return &lampState{
+		Brightness: t.from.Brightness + (t.to.Brightness-t.from.Brightness)*p,
+		Color:      t.from.Color.BlendLab(t.to.Color, p).Clamped(),
+	}, false
+}
+
+func (t *transition) frame(s *lampState) *Change {
+	c := &Change{
+		GroupID: t.key.GroupID,
+		LampID:  t.key.LampID,
+		State:   s.Brightness > 0,
+		Color:   &Color{Color: s.Color},
+	}
+	if c.State {
+		c.Brightness = s.Brightness
+		if c.Brightness < minBrightness {
+			c.Brightness = minBrightness
+		}
+	}
+	return c
+}
+
+// transitioner renders changes with a duration for providers that cannot
+// fade on their own by applying a series of intermediate changes.
+type transitioner struct {
+	mutex       sync.Mutex
+	logger      zerolog.Logger
+	interval    time.Duration
+	timeout     func(providerID string) time.Duration
+	states      map[lampKey]*lampState
+	transitions map[lampKey]*transition
+	wakeChan    chan any
+	closeChan   chan any
+	closedChan  chan any
+}
+
+func newTransitioner(
+	logger zerolog.Logger,
+	frameRate int,
+	timeout func(providerID string) time.Duration,
+) *transitioner {
+	t := &transitioner{
+		logger:      logger,
+		interval:    time.Second / time.Duration(frameRate),
+		timeout:     timeout,
+		states:      make(map[lampKey]*lampState),
+		transitions: make(map[lampKey]*transition),
+		wakeChan:    make(chan any, 1),
+		closeChan:   make(chan any),
+		closedChan:  make(chan any),
+	}
+	go t.run()
+	return t
+}
+
+func (t *transitioner) run() {
+	defer close(t.closedChan)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		if t.idle() {
+			select {
+			case <-t.wakeChan:
+			case <-t.closeChan:
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+			t.render(time.Now())
+		case <-t.closeChan:
+			return
+		}
+	}
+}
+
+func (t *transitioner) idle() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.transitions) == 0
+}
+
+// render computes the next frame for every active transition and applies
+// them, grouping the changes by provider.
+func (t *transitioner) render(now time.Time) {
+	frames := map[Provider][]*Change{}
+	func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		for k, v := range t.transitions {
+			s, done := v.at(now)
+			if done {
+				frames[v.provider] = append(frames[v.provider], v.final)
+				delete(t.transitions, k)
+			} else {
+				frames[v.provider] = append(frames[v.provider], v.frame(s))
+			}
+			t.states[k] = s
+		}
+	}()
+	for p, changes := range frames {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout(p.ID()))
+		if err := Err(p.Apply(ctx, changes)); err != nil {
+			t.logger.Error().Str("provider", p.ID()).Msg(err.Error())
+		}
+		cancel()
+	}
+}
+
+// current returns the current state of the lamp, taking into account any
+// transition that is in progress. The mutex must be held.
+func (t *transitioner) current(p Provider, k lampKey, now time.Time) *lampState {
+	if v, ok := t.transitions[k]; ok {
+		s, _ := v.at(now)
+		return s
+	}
+	if s, ok := t.states[k]; ok {
+		return s
+	}
+	for _, l := range p.Lamps() {
+		if l.GroupID == k.GroupID && l.ID == k.LampID {
+			return newLampState(&Change{State: l.State})
+		}
+	}
+	return newLampState(&Change{})
+}
+
+// record stops any transitions on the lamps being changed and remembers the
+// state they are being set to.
+func (t *transitioner) record(p Provider, changes []*Change) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, c := range changes {
+		k := lampKey{p.ID(), c.GroupID, c.LampID}
+		delete(t.transitions, k)
+		t.states[k] = newLampState(c)
+	}
+}
+
+// start begins transitions for each of the provided changes. The first frame
+// is applied immediately so that invalid changes are reported to the caller;
+// transitions are only started for lamps where it succeeded.
+func (t *transitioner) start(ctx context.Context, p Provider, changes []*Change) []*Result {
+	var (
+		now    = time.Now()
+		frames = []*Change{}
+		trans  = []*transition{}
+	)
+	func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		for _, c := range changes {
+			var (
+				k    = lampKey{p.ID(), c.GroupID, c.LampID}
+				from = t.current(p, k, now)
+				to   = newLampState(c)
+			)
+			if c.Color == nil {
+				to.Color = from.Color
+			}
+			final := *c
+			final.Duration = 0
+			v := &transition{
+				provider: p,
+				key:      k,
+				from:     from,
+				to:       to,
+				start:    now,
+				duration: time.Duration(c.Duration) * time.Millisecond,
+				final:    &final,
+			}
+			frames = append(frames, v.frame(from))
+			trans = append(trans, v)
+		}
+	}()
+	results := p.Apply(ctx, frames)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, v := range trans {
+		if i < len(results) && results[i].Status == ResultApplied {
+			t.transitions[v.key] = v
+		}
+	}
+	select {
+	case t.wakeChan <- nil:
+	default:
+	}
+	return results
+}
+
+// active determines whether a transition is in progress for the lamp.
+func (t *transitioner) active(k lampKey) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.transitions[k]
+	return ok
+}
+
+// forget removes all transitions and state for the specified provider.
+func (t *transitioner) forget(providerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for k := range t.transitions {
+		if k.ProviderID == providerID {
+			delete(t.transitions, k)
+		}
+	}
+	for k := range t.states {
+		if k.ProviderID == providerID {
+			delete(t.states, k)
+		}
+	}
+}
+
+func (t *transitioner) close() {
+	close(t.closeChan)
+	<-t.closedChan
+}
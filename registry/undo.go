@@ -0,0 +1,179 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// maxUndo is the number of batches that are remembered for undo.
+const maxUndo = 50
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// undoBatch contains the changes applied by a single request along with the
+// changes that return each lamp to the state it was in beforehand.
+type undoBatch struct {
+	mutex   sync.Mutex
+	session string
+	before  []*Change
+	after   []*Change
+	seen    map[lampKey]bool
+}
+
+// add records a change that was applied. Only the first state of each lamp
+// is kept so that undo returns it to where it was before the batch.
+func (b *undoBatch) add(k lampKey, before, after *Change) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	a := *after
+	a.ProviderID = k.ProviderID
+	b.after = append(b.after, &a)
+	if b.seen[k] {
+		return
+	}
+	b.seen[k] = true
+	v := *before
+	v.ProviderID = k.ProviderID
+	b.before = append(b.before, &v)
+}
+
+type undoBatchKey struct{}
+
+type skipUndoKey struct{}
+
+// undoable determines whether changes from the source can be undone. Only
+// changes made by clients can be; anything done by lampctl itself (such as
+// jobs, rules, effects, and the presence simulation) or outside of it would
+// otherwise push the changes that clients made off of the undo stack.
+func undoable(ctx context.Context) bool {
+	if v, _ := ctx.Value(skipUndoKey{}).(bool); v {
+		return false
+	}
+	switch SourceFrom(ctx).Type {
+	case SourceREST, SourceWebsocket:
+		return true
+	}
+	return false
+}
+
+// beginBatch returns the batch that changes made with the context belong to,
+// creating one if necessary. If owned is true, the caller must finish it
+// with endBatch. A nil batch is returned if the changes cannot be undone.
+func (r *Registry) beginBatch(ctx context.Context) (context.Context, *undoBatch, bool) {
+	if !undoable(ctx) {
+		return ctx, nil, false
+	}
+	if b, ok := ctx.Value(undoBatchKey{}).(*undoBatch); ok {
+		return ctx, b, false
+	}
+	b := &undoBatch{
+		session: SourceFrom(ctx).Session,
+		seen:    make(map[lampKey]bool),
+	}
+	return context.WithValue(ctx, undoBatchKey{}, b), b, true
+}
+
+// endBatch adds the batch to the undo stack and clears anything that could
+// be redone in the same session.
+func (r *Registry) endBatch(b *undoBatch) {
+	if len(b.before) == 0 {
+		return
+	}
+	r.undoMutex.Lock()
+	defer r.undoMutex.Unlock()
+	r.undo = append(r.undo, b)
+	if len(r.undo) > maxUndo {
+		r.undo = r.undo[len(r.undo)-maxUndo:]
+	}
+	redo := []*undoBatch{}
+	for _, v := range r.redo {
+		if v.session != b.session {
+			redo = append(redo, v)
+		}
+	}
+	r.redo = redo
+}
+
+// beforeChanges determines the current state of each lamp about to be
+// changed so that it can be restored by undo. Lamps without a recorded state
+// use the state reported by the provider.
+func (r *Registry) beforeChanges(p Provider, changes []*Change) map[lampKey]*Change {
+	var (
+		before  = map[lampKey]*Change{}
+		missing = false
+	)
+	for _, c := range changes {
+		k := lampKey{p.ID(), c.GroupID, c.LampID}
+		if v := r.lastChange(k, nil); v != nil {
+			before[k] = v
+		} else {
+			missing = true
+		}
+	}
+	if missing {
+		for _, l := range p.Lamps() {
+			k := lampKey{p.ID(), l.GroupID, l.ID}
+			if _, ok := before[k]; !ok {
+				before[k] = &Change{
+					GroupID: l.GroupID,
+					LampID:  l.ID,
+					State:   l.State,
+				}
+			}
+		}
+	}
+	return before
+}
+
+// popBatch removes the most recent batch in the session (or any session if
+// global is true) from the list.
+func popBatch(list *[]*undoBatch, session string, global bool) *undoBatch {
+	for i := len(*list) - 1; i >= 0; i-- {
+		b := (*list)[i]
+		if global || b.session == session {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return b
+		}
+	}
+	return nil
+}
+
+// Undo returns the lamps changed by the most recent batch of changes to
+// their previous state. If global is false, only batches from the session
+// attached to the context are considered. Either way, the batch can then be
+// redone from that session.
+func (r *Registry) Undo(ctx context.Context, global bool) ([]*Result, error) {
+	session := SourceFrom(ctx).Session
+	r.undoMutex.Lock()
+	b := popBatch(&r.undo, session, global)
+	if b != nil {
+		b.session = session
+		r.redo = append(r.redo, b)
+	}
+	r.undoMutex.Unlock()
+	if b == nil {
+		return nil, ErrNothingToUndo
+	}
+	return r.ApplyChanges(context.WithValue(ctx, skipUndoKey{}, true), b.before), nil
+}
+
+// Redo applies the most recently undone batch of changes again, after which
+// it can be undone from the session attached to the context.
+func (r *Registry) Redo(ctx context.Context, global bool) ([]*Result, error) {
+	session := SourceFrom(ctx).Session
+	r.undoMutex.Lock()
+	b := popBatch(&r.redo, session, global)
+	if b != nil {
+		b.session = session
+		r.undo = append(r.undo, b)
+	}
+	r.undoMutex.Unlock()
+	if b == nil {
+		return nil, ErrNothingToRedo
+	}
+	return r.ApplyChanges(context.WithValue(ctx, skipUndoKey{}, true), b.after), nil
+}
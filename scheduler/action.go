@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lampctl/lampctl/effects"
+	"github.com/lampctl/lampctl/registry"
+)
+
+const (
+	ActionApply    = "apply"
+	ActionScene    = "scene"
+	ActionEffect   = "effect"
+	ActionShow     = "show"
+	ActionPresence = "presence"
+)
+
+var ErrInvalidActionType = errors.New("invalid action type specified")
+
+// EffectAction describes an effect to start. Lamps are specified by targets,
+// a selector, or both.
+type EffectAction struct {
+	Type     string             `json:"type"`
+	Targets  []*registry.Target `json:"targets,omitempty"`
+	Selector *registry.Selector `json:"selector,omitempty"`
+	Params   *effects.Params    `json:"params,omitempty"`
+}
+
+// ShowAction describes a sequence to load and play.
+type ShowAction struct {
+	AudioFilename   string `json:"audio_filename,omitempty"`
+	MidiFilename    string `json:"midi_filename"`
+	MappingFilename string `json:"mapping_filename"`
+}
+
+// Action is performed when a job runs. The field used depends on the type;
+// Presence enables or disables the presence simulation.
+type Action struct {
+	Type     string             `json:"type"`
+	Changes  []*registry.Change `json:"changes,omitempty"`
+	SceneID  int64              `json:"scene_id,omitempty"`
+	Effect   *EffectAction      `json:"effect,omitempty"`
+	Show     *ShowAction        `json:"show,omitempty"`
+	Presence *bool              `json:"presence,omitempty"`
+}
+
+// ValidateAction ensures that the action has everything needed for its type.
+func (s *Scheduler) ValidateAction(a *Action) error {
+	if a == nil {
+		return registry.Rejectf("action is required")
+	}
+	switch a.Type {
+	case ActionApply:
+		if len(a.Changes) == 0 {
+			return registry.Rejectf("at least one change is required")
+		}
+		for _, c := range a.Changes {
+			if c.Selector == nil && c.ProviderID == "" {
+				return registry.Rejectf("each change requires a provider or selector")
+			}
+		}
+	case ActionScene:
+		if _, err := s.registry.Scene(a.SceneID); err != nil {
+			return err
+		}
+	case ActionEffect:
+		if a.Effect == nil || a.Effect.Type == "" {
+			return registry.Rejectf("effect type is required")
+		}
+		if len(a.Effect.Targets) == 0 && a.Effect.Selector == nil {
+			return registry.Rejectf("effect requires targets or a selector")
+		}
+	case ActionShow:
+		if a.Show == nil || a.Show.MidiFilename == "" || a.Show.MappingFilename == "" {
+			return registry.Rejectf("show requires MIDI and mapping filenames")
+		}
+	case ActionPresence:
+		if a.Presence == nil {
+			return registry.Rejectf("presence requires true or false")
+		}
+	default:
+		return ErrInvalidActionType
+	}
+	return nil
+}
+
+// PerformAction carries out the action.
+func (s *Scheduler) PerformAction(ctx context.Context, a *Action) error {
+	switch a.Type {
+	case ActionApply:
+		return registry.Err(s.registry.ApplyChanges(ctx, a.Changes))
+	case ActionScene:
+		results, err := s.registry.ApplyScene(ctx, a.SceneID)
+		if err != nil {
+			return err
+		}
+		return registry.Err(results)
+	case ActionEffect:
+		targets := a.Effect.Targets
+		if a.Effect.Selector != nil {
+			targets = append(
+				append([]*registry.Target{}, targets...),
+				s.registry.Select(a.Effect.Selector, "")...,
+			)
+		}
+
+		// The engine fills in defaults, which must not change the job
+		params := &effects.Params{}
+		if a.Effect.Params != nil {
+			*params = *a.Effect.Params
+		}
+		_, err := s.effects.Start(a.Effect.Type, targets, params)
+		return err
+	case ActionShow:
+		if err := s.sequencer.Load(
+			a.Show.AudioFilename,
+			a.Show.MidiFilename,
+			a.Show.MappingFilename,
+		); err != nil {
+			return err
+		}
+		s.sequencer.Play()
+		return nil
+	case ActionPresence:
+		return s.presence.SetEnabled(*a.Presence)
+	}
+	return ErrInvalidActionType
+}
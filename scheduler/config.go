@@ -0,0 +1,18 @@
+package scheduler
+
+import (
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/effects"
+	"github.com/lampctl/lampctl/presence"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/sequencer"
+)
+
+// Config provides the configuration for the scheduler.
+type Config struct {
+	DB        *db.Conn
+	Registry  *registry.Registry
+	Effects   *effects.Engine
+	Sequencer *sequencer.Sequencer
+	Presence  *presence.Presence
+}
@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lampctl/lampctl/registry"
+)
+
+// cronSearchYears limits how far ahead the next run is searched for so that
+// expressions that can never match (such as February 30th) terminate.
+const cronSearchYears = 5
+
+var (
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	cronMonths = []string{
+		"jan", "feb", "mar", "apr", "may", "jun",
+		"jul", "aug", "sep", "oct", "nov", "dec",
+	}
+	cronDays = []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat",
+	}
+)
+
+// cronField describes the range and names of a single field.
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = []*cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: cronMonths},
+	{name: "day of week", min: 0, max: 7, names: cronDays},
+}
+
+// cronSchedule is a parsed cron expression. Each field is a bitmask of the
+// values that match.
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// If both day fields are restricted, a day matches if either does
+	domStar bool
+	dowStar bool
+}
+
+// parseCron parses a standard five-field cron expression (minute, hour, day
+// of month, month, and day of week) or one of the @ macros.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if v, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = v
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, registry.Rejectf(
+			"cron expression %q must have %d fields",
+			expr,
+			len(cronFields),
+		)
+	}
+	masks := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := cronFields[i].parse(f)
+		if err != nil {
+			return nil, err
+		}
+		masks[i] = v
+	}
+
+	// Sunday may be specified as either 0 or 7
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute:  masks[0],
+		hour:    masks[1],
+		dom:     masks[2],
+		month:   masks[3],
+		dow:     masks[4],
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}, nil
+}
+
+// parse converts a comma-separated list of values, ranges, and steps into
+// a bitmask.
+func (f *cronField) parse(s string) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(s, ",") {
+		var (
+			rangePart = part
+			step      = 1
+		)
+		if i := strings.Index(part, "/"); i != -1 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return 0, registry.Rejectf("invalid step in %s field %q", f.name, s)
+			}
+			rangePart, step = part[:i], v
+		}
+		var lo, hi int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangePart, "-"):
+			i := strings.Index(rangePart, "-")
+			a, err := f.value(rangePart[:i])
+			if err != nil {
+				return 0, err
+			}
+			b, err := f.value(rangePart[i+1:])
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = a, b
+		default:
+			v, err := f.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+
+			// A single value with a step (such as 5/15) runs to the end
+			if step > 1 {
+				hi = f.max
+			}
+		}
+		if lo > hi {
+			return 0, registry.Rejectf("invalid range in %s field %q", f.name, s)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// value parses a single number or name, ensuring it is within range.
+func (f *cronField) value(s string) (int, error) {
+	for i, n := range f.names {
+		if strings.EqualFold(s, n) {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, registry.Rejectf("invalid value in %s field %q", f.name, s)
+	}
+	return v, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	var (
+		dom = c.dom&(1<<uint(t.Day())) != 0
+		dow = c.dow&(1<<uint(t.Weekday())) != 0
+	)
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// wallClock returns the date and time shown on a clock at t, ignoring the
+// offset of its location.
+func wallClock(t time.Time) time.Time {
+	return time.Date(
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), 0, 0, time.UTC,
+	)
+}
+
+// next returns the first time after t (in t's location) that matches the
+// schedule or the zero time if there is none. Times that do not exist
+// because the clocks go forward are skipped. When the clocks go back,
+// schedules restricted to certain hours do not match the repeated times
+// again, while those that run every hour continue to.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	var (
+		loc      = t.Location()
+		limit    = t.Year() + cronSearchYears
+		from     = wallClock(t)
+		allHours = c.hour == 1<<24-1
+	)
+	t = time.Date(
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), 0, 0, loc,
+	).Add(time.Minute)
+	for t.Year() <= limit {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 ||
+			(!allHours && !wallClock(t).After(from)) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseCron(t *testing.T) {
+	for _, v := range []struct {
+		name  string
+		expr  string
+		valid bool
+	}{
+		{"every minute", "* * * * *", true},
+		{"steps", "*/15 * * * *", true},
+		{"range with step", "0 9-17/2 * * *", true},
+		{"names", "0 8 * jan-mar mon,wed,FRI", true},
+		{"sunday as 7", "0 0 * * 7", true},
+		{"macro", "@daily", true},
+		{"macro case", "@Hourly", true},
+		{"too few fields", "* * * *", false},
+		{"too many fields", "* * * * * *", false},
+		{"minute out of range", "60 * * * *", false},
+		{"hour out of range", "0 24 * * *", false},
+		{"day out of range", "0 0 0 * *", false},
+		{"reversed range", "5-1 * * * *", false},
+		{"zero step", "*/0 * * * *", false},
+		{"unknown name", "0 0 * * xyz", false},
+		{"unknown macro", "@fortnightly", false},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			_, err := parseCron(v.expr)
+			if v.valid && err != nil {
+				t.Fatalf("%q: %s", v.expr, err)
+			}
+			if !v.valid && err == nil {
+				t.Fatalf("%q: expected an error", v.expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	var (
+		utc     = time.UTC
+		eastern = mustLoadLocation(t, "America/New_York")
+	)
+	for _, v := range []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "next minute",
+			expr: "* * * * *",
+			from: time.Date(2024, 1, 1, 12, 0, 30, 0, utc),
+			want: time.Date(2024, 1, 1, 12, 1, 0, 0, utc),
+		},
+		{
+			name: "same minute is skipped",
+			expr: "0 12 * * *",
+			from: time.Date(2024, 1, 1, 12, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 2, 12, 0, 0, 0, utc),
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			from: time.Date(2024, 1, 1, 12, 16, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 12, 30, 0, 0, utc),
+		},
+		{
+			name: "hour wraps to the next day",
+			expr: "30 6 * * *",
+			from: time.Date(2024, 1, 1, 23, 59, 0, 0, utc),
+			want: time.Date(2024, 1, 2, 6, 30, 0, 0, utc),
+		},
+		{
+			name: "month wraps to the next year",
+			expr: "0 0 1 * *",
+			from: time.Date(2024, 12, 15, 0, 0, 0, 0, utc),
+			want: time.Date(2025, 1, 1, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2024, 3, 1, 0, 0, 0, 0, utc),
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "weekday",
+			expr: "0 9 * * mon-fri",
+			from: time.Date(2024, 1, 5, 10, 0, 0, 0, utc), // Friday
+			want: time.Date(2024, 1, 8, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc), // Monday
+			want: time.Date(2024, 1, 7, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "either day field matches",
+			expr: "0 0 15 * mon",
+			from: time.Date(2024, 1, 9, 0, 0, 0, 0, utc), // Tuesday
+			want: time.Date(2024, 1, 15, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "never matches",
+			expr: "0 0 30 2 *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc),
+			want: time.Time{},
+		},
+		{
+			name: "time skipped by daylight saving",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 3, 10, 0, 0, 0, 0, eastern),
+			want: time.Date(2024, 3, 11, 2, 30, 0, 0, eastern),
+		},
+		{
+			name: "after the clocks go forward",
+			expr: "0 3 * * *",
+			from: time.Date(2024, 3, 10, 0, 0, 0, 0, eastern),
+			want: time.Date(2024, 3, 10, 3, 0, 0, 0, eastern),
+		},
+		{
+			name: "first of a repeated time",
+			expr: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 0, 0, 0, 0, eastern),
+			want: time.Date(2024, 11, 3, 5, 30, 0, 0, utc),
+		},
+		{
+			name: "repeated time runs once",
+			expr: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 5, 30, 0, 0, utc).In(eastern),
+			want: time.Date(2024, 11, 4, 1, 30, 0, 0, eastern),
+		},
+		{
+			name: "every minute through a repeated hour",
+			expr: "* * * * *",
+			from: time.Date(2024, 11, 3, 5, 59, 0, 0, utc).In(eastern),
+			want: time.Date(2024, 11, 3, 6, 0, 0, 0, utc),
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			c, err := parseCron(v.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.next(v.from); !got.Equal(v.want) {
+				t.Fatalf("%q from %s: got %s, want %s", v.expr, v.from, got, v.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package db
+
+import (
+	"time"
+)
+
+// Job stores a scheduled action. One of Cron, At, or Solar is set, depending
+// on when the job runs. The action is encoded as JSON since it uses types
+// from other packages. Handled is the time up to which scheduled runs have
+// been dealt with and is used to detect runs missed during downtime.
+type Job struct {
+	ID       int64  `gorm:"primaryKey"`
+	Name     string `gorm:"not null"`
+	Enabled  bool   `gorm:"not null"`
+	Cron     string `gorm:"not null"`
+	At       *time.Time
+	Solar    string `gorm:"not null;default:''"`
+	Timezone string `gorm:"not null"`
+	Missed   string `gorm:"not null"`
+	Action   string `gorm:"not null"`
+	LastRun  *time.Time
+	Handled  time.Time `gorm:"not null"`
+}
@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"time"
+)
+
+const (
+	EventSaved   = "saved"
+	EventDeleted = "deleted"
+	EventRun     = "run"
+	EventMissed  = "missed"
+)
+
+// Event indicates that a job was saved, deleted, or run, or that a run was
+// skipped because it was missed. Scheduled is the time the run was due and
+// is nil for jobs run on request.
+type Event struct {
+	Type      string     `json:"type"`
+	JobID     int64      `json:"job_id"`
+	Job       *Job       `json:"job,omitempty"`
+	Scheduled *time.Time `json:"scheduled,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Watch registers a function that is invoked for every event.
+func (s *Scheduler) Watch(fn func(e *Event)) {
+	s.watchers.Add(fn)
+}
+
+func (s *Scheduler) notify(e *Event) {
+	s.watchers.Notify(e)
+}
@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+)
+
+const (
+	keyLatitude  = "location.latitude"
+	keyLongitude = "location.longitude"
+)
+
+var ErrNoLocation = errors.New("location has not been set")
+
+// Location is the position on Earth used to calculate solar triggers.
+// Latitude is positive north of the equator and longitude is positive east
+// of the prime meridian.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (s *Scheduler) loadLocation() error {
+	lat, err := s.db.GetFloatSetting(keyLatitude, math.NaN())
+	if err != nil {
+		return err
+	}
+	lon, err := s.db.GetFloatSetting(keyLongitude, math.NaN())
+	if err != nil {
+		return err
+	}
+	if !math.IsNaN(lat) && !math.IsNaN(lon) {
+		s.location = &Location{
+			Latitude:  lat,
+			Longitude: lon,
+		}
+	}
+	return nil
+}
+
+// Location returns the location used for solar triggers or nil if it has
+// not been set.
+func (s *Scheduler) Location() *Location {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.location == nil {
+		return nil
+	}
+	l := *s.location
+	return &l
+}
+
+// SetLocation stores the location and reschedules all jobs and callbacks
+// with solar triggers.
+func (s *Scheduler) SetLocation(l *Location) error {
+	if l.Latitude < -90 || l.Latitude > 90 {
+		return registry.Rejectf("latitude must be between -90 and 90")
+	}
+	if l.Longitude < -180 || l.Longitude > 180 {
+		return registry.Rejectf("longitude must be between -180 and 180")
+	}
+	if err := s.db.Transaction(func(conn *db.Conn) error {
+		if err := conn.SetFloatSetting(keyLatitude, l.Latitude); err != nil {
+			return err
+		}
+		return conn.SetFloatSetting(keyLongitude, l.Longitude)
+	}); err != nil {
+		return err
+	}
+	var (
+		now = time.Now()
+		v   = *l
+	)
+	s.mutex.Lock()
+	s.location = &v
+	for _, e := range s.entries {
+		if e.solar != nil {
+			e.location = &v
+			e.next = e.after(now)
+		}
+	}
+	for _, e := range s.callbacks {
+		if e.solar != nil {
+			e.location = &v
+			e.next = e.after(now)
+		}
+	}
+	s.mutex.Unlock()
+	s.wake()
+	return nil
+}
@@ -0,0 +1,598 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/effects"
+	"github.com/lampctl/lampctl/presence"
+	"github.com/lampctl/lampctl/registry"
+	scheduler_db "github.com/lampctl/lampctl/scheduler/db"
+	"github.com/lampctl/lampctl/sequencer"
+	"github.com/lampctl/lampctl/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	MissedSkip = "skip"
+	MissedRun  = "run"
+
+	defaultTimezone     = "Local"
+	defaultPreviewCount = 5
+	maxPreviewCount     = 100
+
+	// missedGrace is how late a run may start before it is considered
+	// missed, such as after downtime or when the clock is corrected
+	missedGrace = time.Minute
+)
+
+var ErrInvalidJob = errors.New("invalid job specified")
+
+// Job performs an action on a schedule. Repeating jobs use a cron expression
+// evaluated in the timezone or a solar trigger, such as "sunset - 15m";
+// one-shot jobs specify At instead and are disabled once they run. Missed
+// determines whether a run missed while lampctl was not running happens when
+// it starts again.
+type Job struct {
+	ID       int64      `json:"id"`
+	Name     string     `json:"name"`
+	Enabled  bool       `json:"enabled"`
+	Cron     string     `json:"cron,omitempty"`
+	At       *time.Time `json:"at,omitempty"`
+	Solar    string     `json:"solar,omitempty"`
+	Timezone string     `json:"timezone"`
+	Missed   string     `json:"missed"`
+	Action   *Action    `json:"action"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+}
+
+func newJob(v *scheduler_db.Job) (*Job, error) {
+	j := &Job{
+		ID:       v.ID,
+		Name:     v.Name,
+		Enabled:  v.Enabled,
+		Cron:     v.Cron,
+		At:       v.At,
+		Solar:    v.Solar,
+		Timezone: v.Timezone,
+		Missed:   v.Missed,
+		LastRun:  v.LastRun,
+	}
+	if err := json.Unmarshal([]byte(v.Action), &j.Action); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// entry is a job that has been loaded along with its parsed schedule and
+// the time of its next run. Entries added with Schedule call fn instead of
+// performing the job's action.
+type entry struct {
+	job      *Job
+	schedule *cronSchedule
+	solar    *solarTrigger
+	location *Location
+	loc      *time.Location
+	next     time.Time
+	fn       func()
+}
+
+func newEntry(j *Job, l *Location) (*entry, error) {
+	if j.Timezone == "" {
+		j.Timezone = defaultTimezone
+	}
+	loc, err := util.LoadTimezone(j.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{
+		job:      j,
+		location: l,
+		loc:      loc,
+	}
+	n := 0
+	for _, v := range []bool{j.Cron != "", j.At != nil, j.Solar != ""} {
+		if v {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, registry.Rejectf("exactly one of cron, at, or solar is required")
+	}
+	switch {
+	case j.Cron != "":
+		s, err := parseCron(j.Cron)
+		if err != nil {
+			return nil, err
+		}
+		e.schedule = s
+	case j.Solar != "":
+		if l == nil {
+			return nil, ErrNoLocation
+		}
+		s, err := parseSolar(j.Solar)
+		if err != nil {
+			return nil, err
+		}
+		e.solar = s
+	}
+	return e, nil
+}
+
+// after returns the first run of the job after t or the zero time if it
+// will not run again.
+func (e *entry) after(t time.Time) time.Time {
+	if e.schedule != nil {
+		return e.schedule.next(t.In(e.loc))
+	}
+	if e.solar != nil {
+		return e.solar.next(t.In(e.loc), e.location)
+	}
+	if e.job.At.After(t) {
+		return e.job.At.In(e.loc)
+	}
+	return time.Time{}
+}
+
+// Scheduler runs jobs stored in the database at the times they specify.
+type Scheduler struct {
+	mutex      sync.Mutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	registry   *registry.Registry
+	effects    *effects.Engine
+	sequencer  *sequencer.Sequencer
+	presence   *presence.Presence
+	entries    map[int64]*entry
+	callbacks  map[string]*entry
+	location   *Location
+	watchers   util.Watchers[*Event]
+	running    sync.WaitGroup
+	wakeChan   chan any
+	closeChan  chan any
+	closedChan chan any
+}
+
+func (s *Scheduler) run() {
+	defer close(s.closedChan)
+	defer s.logger.Info().Msg("scheduler stopped")
+	s.logger.Info().Msg("scheduler started")
+	for {
+		s.runDue(time.Now())
+		wait := util.MaxSleep
+		if next := s.nextRun(); !next.IsZero() {
+			if d := time.Until(next); d < wait {
+				wait = d
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wakeChan:
+		case <-s.closeChan:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeChan <- nil:
+	default:
+	}
+}
+
+// nextRun returns the earliest time that any job is due.
+func (s *Scheduler) nextRun() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var next time.Time
+	for _, e := range s.entries {
+		if !e.job.Enabled || e.next.IsZero() {
+			continue
+		}
+		if next.IsZero() || e.next.Before(next) {
+			next = e.next
+		}
+	}
+	for _, e := range s.callbacks {
+		if e.next.IsZero() {
+			continue
+		}
+		if next.IsZero() || e.next.Before(next) {
+			next = e.next
+		}
+	}
+	return next
+}
+
+// runDue starts every job that is due. Runs that are late by more than the
+// grace period are skipped unless the job asks for missed runs; either way,
+// only a single run happens no matter how many were missed.
+func (s *Scheduler) runDue(now time.Time) {
+	var (
+		missed = []*Event{}
+		due    = []func(){}
+	)
+	func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, e := range s.entries {
+			if !e.job.Enabled || e.next.IsZero() || e.next.After(now) {
+				continue
+			}
+			scheduled := e.next
+			e.next = e.after(now)
+			updates := map[string]interface{}{
+				"handled": now.UTC(),
+			}
+			if e.job.At != nil {
+				e.job.Enabled = false
+				updates["enabled"] = false
+			}
+			if now.Sub(scheduled) > missedGrace && e.job.Missed != MissedRun {
+				s.logger.Warn().
+					Int64("job", e.job.ID).
+					Msgf("skipped run missed at %s", scheduled.Format(time.RFC3339))
+				s.update(e.job.ID, updates)
+				missed = append(missed, &Event{
+					Type:      EventMissed,
+					JobID:     e.job.ID,
+					Scheduled: &scheduled,
+				})
+				continue
+			}
+			t := now
+			e.job.LastRun = &t
+			updates["last_run"] = now.UTC()
+			s.update(e.job.ID, updates)
+			s.start(e.job, scheduled)
+		}
+		for key, e := range s.callbacks {
+			if e.next.IsZero() || e.next.After(now) {
+				continue
+			}
+			scheduled := e.next
+			e.next = e.after(now)
+			if now.Sub(scheduled) > missedGrace {
+				s.logger.Warn().
+					Str("callback", key).
+					Msgf("skipped run missed at %s", scheduled.Format(time.RFC3339))
+				continue
+			}
+			due = append(due, e.fn)
+		}
+	}()
+	for _, e := range missed {
+		s.notify(e)
+	}
+	for _, fn := range due {
+		fn()
+	}
+}
+
+func (s *Scheduler) update(id int64, updates map[string]interface{}) {
+	if err := s.db.
+		Model(&scheduler_db.Job{ID: id}).
+		Updates(updates).Error; err != nil {
+		s.logger.Error().Int64("job", id).Msg(err.Error())
+	}
+}
+
+// start performs the job's action in a separate goroutine so that slow
+// actions do not delay other jobs.
+func (s *Scheduler) start(j *Job, scheduled time.Time) {
+	var (
+		id     = j.ID
+		action = j.Action
+	)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.logger.Info().Int64("job", id).Msg("running job")
+		ctx := registry.WithSource(
+			context.Background(),
+			registry.SourceScheduler,
+			strconv.FormatInt(id, 10),
+		)
+		e := &Event{
+			Type:      EventRun,
+			JobID:     id,
+			Scheduled: &scheduled,
+		}
+		if err := s.PerformAction(ctx, action); err != nil {
+			s.logger.Error().Int64("job", id).Msg(err.Error())
+			e.Error = err.Error()
+		}
+		s.notify(e)
+	}()
+}
+
+// New creates and starts a new scheduler.
+func New(cfg *Config) (*Scheduler, error) {
+	s := &Scheduler{
+		logger:     log.With().Str("package", "scheduler").Logger(),
+		db:         cfg.DB,
+		registry:   cfg.Registry,
+		effects:    cfg.Effects,
+		sequencer:  cfg.Sequencer,
+		presence:   cfg.Presence,
+		entries:    make(map[int64]*entry),
+		callbacks:  make(map[string]*entry),
+		wakeChan:   make(chan any, 1),
+		closeChan:  make(chan any),
+		closedChan: make(chan any),
+	}
+	if err := s.db.AutoMigrate(&scheduler_db.Job{}); err != nil {
+		return nil, err
+	}
+	if err := s.loadLocation(); err != nil {
+		return nil, err
+	}
+	rows := []*scheduler_db.Job{}
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range rows {
+		j, err := newJob(v)
+		if err != nil {
+			return nil, err
+		}
+		e, err := newEntry(j, s.location)
+		if err != nil {
+			s.logger.Error().Int64("job", j.ID).Msg(err.Error())
+			continue
+		}
+
+		// Runs between the last time the job was handled and now were
+		// missed and are dealt with as soon as the scheduler starts
+		e.next = e.after(v.Handled)
+		s.entries[j.ID] = e
+	}
+	go s.run()
+	return s, nil
+}
+
+// Jobs returns all stored jobs.
+func (s *Scheduler) Jobs() ([]*Job, error) {
+	rows := []*scheduler_db.Job{}
+	if err := s.db.Order("name").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	jobs := []*Job{}
+	for _, v := range rows {
+		j, err := newJob(v)
+		if err != nil {
+			return nil, err
+		}
+		s.setNextRun(j)
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Job retrieves the job with the specified ID.
+func (s *Scheduler) Job(id int64) (*Job, error) {
+	v := &scheduler_db.Job{}
+	if err := s.db.First(v, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidJob
+		}
+		return nil, err
+	}
+	j, err := newJob(v)
+	if err != nil {
+		return nil, err
+	}
+	s.setNextRun(j)
+	return j, nil
+}
+
+func (s *Scheduler) setNextRun(j *Job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if e, ok := s.entries[j.ID]; ok && e.job.Enabled && !e.next.IsZero() {
+		next := e.next
+		j.NextRun = &next
+	}
+}
+
+// validateJob checks the job and returns an entry for it.
+func (s *Scheduler) validateJob(j *Job) (*entry, error) {
+	j.Name = strings.TrimSpace(j.Name)
+	if j.Name == "" {
+		return nil, registry.Rejectf("job name is required")
+	}
+	switch j.Missed {
+	case "":
+		j.Missed = MissedSkip
+	case MissedSkip, MissedRun:
+	default:
+		return nil, registry.Rejectf("invalid missed run behavior %q", j.Missed)
+	}
+	e, err := newEntry(j, s.Location())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ValidateAction(j.Action); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SaveJob stores the job in the database, creating it if the ID is zero.
+// Runs are scheduled from the time the job is saved.
+func (s *Scheduler) SaveJob(j *Job) error {
+	e, err := s.validateJob(j)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if j.Enabled && j.At != nil && !j.At.After(now) {
+		return registry.Rejectf("at must be in the future")
+	}
+	v := &scheduler_db.Job{}
+	if j.ID != 0 {
+		if err := s.db.First(v, j.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidJob
+			}
+			return err
+		}
+	}
+	b, err := json.Marshal(j.Action)
+	if err != nil {
+		return err
+	}
+	v.Name = j.Name
+	v.Enabled = j.Enabled
+	v.Cron = j.Cron
+	v.At = j.At
+	v.Solar = j.Solar
+	v.Timezone = j.Timezone
+	v.Missed = j.Missed
+	v.Action = string(b)
+	v.Handled = now.UTC()
+	if err := s.db.Save(v).Error; err != nil {
+		return err
+	}
+	j.ID = v.ID
+	j.LastRun = v.LastRun
+	j.NextRun = nil
+	e.next = e.after(now)
+	if j.Enabled && !e.next.IsZero() {
+		next := e.next
+		j.NextRun = &next
+	}
+	stored := *j
+	s.mutex.Lock()
+	e.job = &stored
+	s.entries[j.ID] = e
+	s.mutex.Unlock()
+	s.wake()
+	s.notify(&Event{
+		Type:  EventSaved,
+		JobID: j.ID,
+		Job:   j,
+	})
+	return nil
+}
+
+// DeleteJob removes the job with the specified ID.
+func (s *Scheduler) DeleteJob(id int64) error {
+	if _, err := s.Job(id); err != nil {
+		return err
+	}
+	if err := s.db.Delete(&scheduler_db.Job{}, id).Error; err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	delete(s.entries, id)
+	s.mutex.Unlock()
+	s.wake()
+	s.notify(&Event{
+		Type:  EventDeleted,
+		JobID: id,
+	})
+	return nil
+}
+
+// RunJob performs the job's action immediately, regardless of its schedule.
+func (s *Scheduler) RunJob(id int64) error {
+	j, err := s.Job(id)
+	if err != nil {
+		return err
+	}
+	ctx := registry.WithSource(
+		context.Background(),
+		registry.SourceScheduler,
+		strconv.FormatInt(id, 10),
+	)
+	now := time.Now()
+	s.update(id, map[string]interface{}{
+		"last_run": now.UTC(),
+	})
+	s.mutex.Lock()
+	if e, ok := s.entries[id]; ok {
+		e.job.LastRun = &now
+	}
+	s.mutex.Unlock()
+	e := &Event{
+		Type:  EventRun,
+		JobID: id,
+	}
+	err = s.PerformAction(ctx, j.Action)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.notify(e)
+	return err
+}
+
+// Preview returns the times of the next runs of the job (up to count) after
+// the specified time. The job does not need to have been saved.
+func (s *Scheduler) Preview(j *Job, from time.Time, count int) ([]time.Time, error) {
+	e, err := newEntry(j, s.Location())
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		count = defaultPreviewCount
+	}
+	if count > maxPreviewCount {
+		count = maxPreviewCount
+	}
+	times := []time.Time{}
+	for t := from; len(times) < count; {
+		t = e.after(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// Schedule calls fn at the times the job specifies, replacing any callback
+// previously scheduled with the same key. Only the job's schedule is used and
+// nothing is stored, so missed runs are always skipped. Solar triggers follow
+// changes to the location. fn is called from the scheduler's goroutine and
+// must not block.
+func (s *Scheduler) Schedule(key string, j *Job, fn func()) error {
+	e, err := newEntry(j, s.Location())
+	if err != nil {
+		return err
+	}
+	e.fn = fn
+	e.next = e.after(time.Now())
+	s.mutex.Lock()
+	s.callbacks[key] = e
+	s.mutex.Unlock()
+	s.wake()
+	return nil
+}
+
+// Unschedule removes the callback with the specified key, if any.
+func (s *Scheduler) Unschedule(key string) {
+	s.mutex.Lock()
+	delete(s.callbacks, key)
+	s.mutex.Unlock()
+}
+
+// Close waits for running actions to finish and shuts down the scheduler.
+func (s *Scheduler) Close() {
+	close(s.closeChan)
+	<-s.closedChan
+	s.running.Wait()
+}
@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lampctl/lampctl/registry"
+)
+
+const (
+	SolarSunrise          = "sunrise"
+	SolarSunset           = "sunset"
+	SolarCivilDawn        = "civil_dawn"
+	SolarCivilDusk        = "civil_dusk"
+	SolarNauticalDawn     = "nautical_dawn"
+	SolarNauticalDusk     = "nautical_dusk"
+	SolarAstronomicalDawn = "astronomical_dawn"
+	SolarAstronomicalDusk = "astronomical_dusk"
+
+	// maxSolarOffset limits offsets so that a trigger always falls on the
+	// same day as its event, give or take one
+	maxSolarOffset = 12 * time.Hour
+
+	// solarSearchDays is how far ahead events are searched for; near the
+	// poles, the sun may not rise or set for months
+	solarSearchDays = 370
+)
+
+// solarEvent describes when an event occurs in terms of the angle of the
+// sun from directly overhead.
+type solarEvent struct {
+	zenith float64
+	rising bool
+}
+
+var solarEvents = map[string]*solarEvent{
+	SolarSunrise:          {zenith: 90.833, rising: true},
+	SolarSunset:           {zenith: 90.833},
+	SolarCivilDawn:        {zenith: 96, rising: true},
+	SolarCivilDusk:        {zenith: 96},
+	SolarNauticalDawn:     {zenith: 102, rising: true},
+	SolarNauticalDusk:     {zenith: 102},
+	SolarAstronomicalDawn: {zenith: 108, rising: true},
+	SolarAstronomicalDusk: {zenith: 108},
+}
+
+// solarTrigger is a parsed solar expression such as "sunset - 15m".
+type solarTrigger struct {
+	event  *solarEvent
+	offset time.Duration
+}
+
+func parseSolar(expr string) (*solarTrigger, error) {
+	s := strings.ToLower(strings.Join(strings.Fields(expr), ""))
+	var (
+		name   = s
+		offset time.Duration
+	)
+	if i := strings.IndexAny(s, "+-"); i != -1 {
+		v, err := time.ParseDuration(s[i+1:])
+		if err != nil {
+			return nil, registry.Rejectf("invalid offset in solar trigger %q", expr)
+		}
+		if s[i] == '-' {
+			v = -v
+		}
+		name, offset = s[:i], v
+	}
+	e, ok := solarEvents[name]
+	if !ok {
+		return nil, registry.Rejectf("invalid solar event %q", name)
+	}
+	if offset > maxSolarOffset || offset < -maxSolarOffset {
+		return nil, registry.Rejectf("solar offset cannot exceed %s", maxSolarOffset)
+	}
+	return &solarTrigger{
+		event:  e,
+		offset: offset,
+	}, nil
+}
+
+// next returns the first time after t (in t's location) that the trigger
+// fires at the location or the zero time if there is none.
+func (s *solarTrigger) next(t time.Time, l *Location) time.Time {
+	y, m, d := t.Date()
+	for i := -1; i < solarSearchDays; i++ {
+		v, ok := s.event.on(y, m, d+i, l)
+		if !ok {
+			continue
+		}
+		if v = v.Add(s.offset).Truncate(time.Second); v.After(t) {
+			return v.In(t.Location())
+		}
+	}
+	return time.Time{}
+}
+
+func degToRad(v float64) float64 {
+	return v * math.Pi / 180
+}
+
+func radToDeg(v float64) float64 {
+	return v * 180 / math.Pi
+}
+
+// on calculates the time of the event on the specified date using the
+// algorithm from the NOAA solar calculator, which is accurate to within a
+// minute or so for latitudes between +/- 72 degrees. False is returned if
+// the event does not occur that day.
+func (e *solarEvent) on(year int, month time.Month, day int, l *Location) (time.Time, bool) {
+	var (
+		midnight = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		jd       = float64(midnight.Unix())/86400 + 2440587.5
+	)
+
+	// The first pass approximates the time of the event, which is then
+	// used to refine the position of the sun
+	minutes, ok := e.minutes(jd, l)
+	if !ok {
+		return time.Time{}, false
+	}
+	minutes, ok = e.minutes(jd+minutes/1440, l)
+	if !ok {
+		return time.Time{}, false
+	}
+	return midnight.Add(time.Duration(minutes * float64(time.Minute))), true
+}
+
+// minutes returns the time of the event in minutes after midnight UTC.
+func (e *solarEvent) minutes(jd float64, l *Location) (float64, bool) {
+	var (
+		t       = (jd - 2451545) / 36525
+		eqTime  = equationOfTime(t)
+		decl    = degToRad(sunDeclination(t))
+		lat     = degToRad(l.Latitude)
+		cosHour = math.Cos(degToRad(e.zenith))/(math.Cos(lat)*math.Cos(decl)) -
+			math.Tan(lat)*math.Tan(decl)
+	)
+	if cosHour < -1 || cosHour > 1 {
+		return 0, false
+	}
+	hourAngle := radToDeg(math.Acos(cosHour))
+	if !e.rising {
+		hourAngle = -hourAngle
+	}
+	return 720 - 4*(l.Longitude+hourAngle) - eqTime, true
+}
+
+func geomMeanLongSun(t float64) float64 {
+	return math.Mod(280.46646+t*(36000.76983+t*0.0003032), 360)
+}
+
+func geomMeanAnomalySun(t float64) float64 {
+	return 357.52911 + t*(35999.05029-0.0001537*t)
+}
+
+func eccentricityEarthOrbit(t float64) float64 {
+	return 0.016708634 - t*(0.000042037+0.0000001267*t)
+}
+
+func sunEqOfCenter(t float64) float64 {
+	m := degToRad(geomMeanAnomalySun(t))
+	return math.Sin(m)*(1.914602-t*(0.004817+0.000014*t)) +
+		math.Sin(2*m)*(0.019993-0.000101*t) +
+		math.Sin(3*m)*0.000289
+}
+
+func sunApparentLong(t float64) float64 {
+	omega := 125.04 - 1934.136*t
+	return geomMeanLongSun(t) + sunEqOfCenter(t) - 0.00569 -
+		0.00478*math.Sin(degToRad(omega))
+}
+
+func obliquityCorrection(t float64) float64 {
+	var (
+		seconds = 21.448 - t*(46.815+t*(0.00059-t*0.001813))
+		e0      = 23 + (26+seconds/60)/60
+		omega   = 125.04 - 1934.136*t
+	)
+	return e0 + 0.00256*math.Cos(degToRad(omega))
+}
+
+func sunDeclination(t float64) float64 {
+	var (
+		e      = degToRad(obliquityCorrection(t))
+		lambda = degToRad(sunApparentLong(t))
+	)
+	return radToDeg(math.Asin(math.Sin(e) * math.Sin(lambda)))
+}
+
+// equationOfTime returns the difference between apparent and mean solar
+// time in minutes.
+func equationOfTime(t float64) float64 {
+	var (
+		epsilon = degToRad(obliquityCorrection(t))
+		l0      = degToRad(geomMeanLongSun(t))
+		e       = eccentricityEarthOrbit(t)
+		m       = degToRad(geomMeanAnomalySun(t))
+		y       = math.Pow(math.Tan(epsilon/2), 2)
+	)
+	v := y*math.Sin(2*l0) -
+		2*e*math.Sin(m) +
+		4*e*y*math.Sin(m)*math.Cos(2*l0) -
+		0.5*y*y*math.Sin(4*l0) -
+		1.25*e*e*math.Sin(2*m)
+	return radToDeg(v) * 4
+}
@@ -0,0 +1,207 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// solarTolerance is how far calculated times may be from published ones,
+// which are rounded to the minute and allow for refraction differently
+const solarTolerance = 2 * time.Minute
+
+var (
+	london   = &Location{Latitude: 51.5074, Longitude: -0.1278}
+	newYork  = &Location{Latitude: 40.7128, Longitude: -74.0060}
+	honolulu = &Location{Latitude: 21.3069, Longitude: -157.8583}
+	sydney   = &Location{Latitude: -33.8688, Longitude: 151.2093}
+	tromso   = &Location{Latitude: 69.6492, Longitude: 18.9553}
+)
+
+func TestParseSolar(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		expr   string
+		offset time.Duration
+		valid  bool
+	}{
+		{"event", "sunset", 0, true},
+		{"positive offset", "sunrise + 30m", 30 * time.Minute, true},
+		{"negative offset", "civil_dusk-1h15m", -75 * time.Minute, true},
+		{"case and spacing", " Sunset  -  15m ", -15 * time.Minute, true},
+		{"maximum offset", "sunset + 12h", 12 * time.Hour, true},
+		{"unknown event", "noon", 0, false},
+		{"invalid offset", "sunset + soon", 0, false},
+		{"offset too large", "sunrise - 13h", 0, false},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			s, err := parseSolar(v.expr)
+			if !v.valid {
+				if err == nil {
+					t.Fatalf("%q: expected an error", v.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q: %s", v.expr, err)
+			}
+			if s.offset != v.offset {
+				t.Fatalf("%q: got offset %s, want %s", v.expr, s.offset, v.offset)
+			}
+		})
+	}
+}
+
+func TestSolarTriggerNext(t *testing.T) {
+	var (
+		europeLondon    = mustLoadLocation(t, "Europe/London")
+		americaNewYork  = mustLoadLocation(t, "America/New_York")
+		pacificHonolulu = mustLoadLocation(t, "Pacific/Honolulu")
+		australiaSydney = mustLoadLocation(t, "Australia/Sydney")
+	)
+	for _, v := range []struct {
+		name     string
+		expr     string
+		location *Location
+		from     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "summer sunrise",
+			expr:     "sunrise",
+			location: london,
+			from:     time.Date(2024, 6, 21, 0, 0, 0, 0, europeLondon),
+			want:     time.Date(2024, 6, 21, 4, 43, 0, 0, europeLondon),
+		},
+		{
+			name:     "summer sunset",
+			expr:     "sunset",
+			location: london,
+			from:     time.Date(2024, 6, 21, 0, 0, 0, 0, europeLondon),
+			want:     time.Date(2024, 6, 21, 21, 21, 0, 0, europeLondon),
+		},
+		{
+			name:     "winter sunrise",
+			expr:     "sunrise",
+			location: newYork,
+			from:     time.Date(2024, 12, 21, 0, 0, 0, 0, americaNewYork),
+			want:     time.Date(2024, 12, 21, 7, 17, 0, 0, americaNewYork),
+		},
+		{
+			name:     "winter sunset",
+			expr:     "sunset",
+			location: newYork,
+			from:     time.Date(2024, 12, 21, 0, 0, 0, 0, americaNewYork),
+			want:     time.Date(2024, 12, 21, 16, 32, 0, 0, americaNewYork),
+		},
+		{
+			name:     "civil dusk",
+			expr:     "civil_dusk",
+			location: newYork,
+			from:     time.Date(2024, 12, 21, 0, 0, 0, 0, americaNewYork),
+			want:     time.Date(2024, 12, 21, 17, 2, 0, 0, americaNewYork),
+		},
+		{
+			name:     "after today's event",
+			expr:     "sunset",
+			location: newYork,
+			from:     time.Date(2024, 12, 21, 17, 0, 0, 0, americaNewYork),
+			want:     time.Date(2024, 12, 22, 16, 33, 0, 0, americaNewYork),
+		},
+		{
+			name:     "offset past midnight",
+			expr:     "sunset + 8h",
+			location: newYork,
+			from:     time.Date(2024, 12, 21, 12, 0, 0, 0, americaNewYork),
+			want:     time.Date(2024, 12, 22, 0, 32, 0, 0, americaNewYork),
+		},
+		{
+			name:     "offset before midnight",
+			expr:     "sunrise - 8h",
+			location: newYork,
+			from:     time.Date(2024, 12, 20, 12, 0, 0, 0, americaNewYork),
+			want:     time.Date(2024, 12, 20, 23, 17, 0, 0, americaNewYork),
+		},
+		{
+			name:     "sunset on the next day in UTC",
+			expr:     "sunset",
+			location: honolulu,
+			from:     time.Date(2024, 6, 21, 0, 0, 0, 0, pacificHonolulu),
+			want:     time.Date(2024, 6, 21, 19, 16, 0, 0, pacificHonolulu),
+		},
+		{
+			name:     "sunrise on the previous day in UTC",
+			expr:     "sunrise",
+			location: sydney,
+			from:     time.Date(2024, 12, 21, 0, 0, 0, 0, australiaSydney),
+			want:     time.Date(2024, 12, 21, 5, 41, 0, 0, australiaSydney),
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			s, err := parseSolar(v.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.next(v.from, v.location)
+			if d := got.Sub(v.want); d < -solarTolerance || d > solarTolerance {
+				t.Fatalf("%q from %s: got %s, want %s", v.expr, v.from, got, v.want)
+			}
+		})
+	}
+}
+
+func TestSolarPolar(t *testing.T) {
+	for _, v := range []struct {
+		name  string
+		event string
+		date  time.Time
+		occur bool
+	}{
+		{"no sunrise in polar day", SolarSunrise, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), false},
+		{"no sunset in polar day", SolarSunset, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), false},
+		{"no sunrise in polar night", SolarSunrise, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), false},
+		{"no sunset in polar night", SolarSunset, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), false},
+		{"civil dawn in polar night", SolarCivilDawn, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), true},
+		{"sunrise in spring", SolarSunrise, time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), true},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			y, m, d := v.date.Date()
+			if _, ok := solarEvents[v.event].on(y, m, d, tromso); ok != v.occur {
+				t.Fatalf("%s on %s: got %t, want %t", v.event, v.date, ok, v.occur)
+			}
+		})
+	}
+	for _, v := range []struct {
+		name     string
+		expr     string
+		from     time.Time
+		earliest time.Time
+		latest   time.Time
+	}{
+		{
+			name:     "first sunset after polar day",
+			expr:     "sunset",
+			from:     time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC),
+			earliest: time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC),
+			latest:   time.Date(2024, 7, 27, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "first sunrise after polar night",
+			expr:     "sunrise",
+			from:     time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC),
+			earliest: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC),
+			latest:   time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			s, err := parseSolar(v.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.next(v.from, tromso)
+			if got.Before(v.earliest) || got.After(v.latest) {
+				t.Fatalf("%q from %s: got %s, want between %s and %s",
+					v.expr, v.from, got, v.earliest, v.latest)
+			}
+		})
+	}
+}
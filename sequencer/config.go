@@ -1,10 +1,12 @@
 package sequencer
 
 import (
+	"github.com/lampctl/lampctl/effects"
 	"github.com/lampctl/lampctl/registry"
 )
 
 // Config provides the configuration for the sequencer.
 type Config struct {
 	Registry *registry.Registry
+	Effects  *effects.Engine
 }
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/lampctl/lampctl/effects"
 	"github.com/lampctl/lampctl/registry"
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/smf"
@@ -18,14 +20,16 @@ type sequencerRawEvent struct {
 	NoteOn bool
 }
 
-type sequencerEvent struct {
-	Provider registry.Provider
-	Changes  []*registry.Change
+type sequencerCue struct {
+	Note   int
+	Start  bool
+	Effect *mappingEffect
 }
 
 type sequencerGroup struct {
-	Offset time.Duration
-	Events []*sequencerEvent
+	Offset  time.Duration
+	Changes []*registry.Change
+	Cues    []*sequencerCue
 }
 
 type sequencerSequence struct {
@@ -66,10 +70,26 @@ func (s *Sequencer) loadRawEvents(midiFilename string) ([]*sequencerRawEvent, er
 	return events, nil
 }
 
+// mappingEffect describes an effect to run on the targets as well as any
+// lamps matched by the selector, which is resolved when the effect starts.
+type mappingEffect struct {
+	Type     string             `json:"type"`
+	Targets  []*registry.Target `json:"targets"`
+	Selector *registry.Selector `json:"selector"`
+	Params   *effects.Params    `json:"params"`
+}
+
+// mappingNote describes the lamp to change for a note, which may be
+// specified by a selector instead of provider, group, and lamp IDs.
 type mappingNote struct {
-	ProviderID string `json:"provider_id"`
-	GroupID    string `json:"group_id"`
-	LampID     string `json:"lamp_id"`
+	ProviderID string             `json:"provider_id"`
+	GroupID    string             `json:"group_id"`
+	LampID     string             `json:"lamp_id"`
+	Selector   *registry.Selector `json:"selector"`
+
+	// Effect, if provided, is started when the note begins and stopped when
+	// it ends instead of changing a single lamp
+	Effect *mappingEffect `json:"effect"`
 }
 
 type mappingMap map[string]*mappingNote
@@ -87,8 +107,6 @@ func (s *Sequencer) loadMap(mappingFilename string) (mappingMap, error) {
 	return m, nil
 }
 
-type changeMap map[registry.Provider][]*registry.Change
-
 func (s *Sequencer) load(midiFilename, mappingFilename string) error {
 
 	// Read the raw MIDI events
@@ -97,52 +115,41 @@ func (s *Sequencer) load(midiFilename, mappingFilename string) error {
 		return err
 	}
 
+	// Events from multiple tracks must be merged in order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Offset < events[j].Offset
+	})
+
 	// Read the mapping file
 	mapping, err := s.loadMap(mappingFilename)
 	if err != nil {
 		return err
 	}
 
-	// Create a map of provider IDs to actual Provider instances
-	providerMap := map[string]registry.Provider{}
+	// Ensure that each of the providers exists; selectors are resolved
+	// during playback instead
 	for _, m := range mapping {
-		if _, ok := providerMap[m.ProviderID]; !ok {
-			p, err := s.registry.GetProvider(m.ProviderID)
-			if err != nil {
-				return err
-			}
-			providerMap[m.ProviderID] = p
+		if m.Effect != nil || m.Selector != nil {
+			continue
+		}
+		if _, err := s.registry.GetProvider(m.ProviderID); err != nil {
+			return fmt.Errorf("provider %s does not exist", m.ProviderID)
 		}
 	}
 
-	// Group the events by their offset and then provider
+	// Group the events by their offset
 	var (
-		sequence          = &sequencerSequence{}
-		currentOffset     time.Duration
-		changesByProvider changeMap
+		sequence     = &sequencerSequence{}
+		currentGroup *sequencerGroup
 	)
 	for _, e := range events {
 
-		// If this is the first event or a new offset...
-		if changesByProvider == nil || e.Offset != currentOffset {
-
-			// Create a sequencerGroup for the events
-			if changesByProvider != nil {
-				g := &sequencerGroup{
-					Offset: currentOffset,
-				}
-				for p, changeList := range changesByProvider {
-					g.Events = append(g.Events, &sequencerEvent{
-						Provider: p,
-						Changes:  changeList,
-					})
-				}
-				sequence.Groups = append(sequence.Groups, g)
+		// If this is the first event or a new offset, start a new group
+		if currentGroup == nil || e.Offset != currentGroup.Offset {
+			currentGroup = &sequencerGroup{
+				Offset: e.Offset,
 			}
-
-			// Reset the current offset and change map
-			currentOffset = e.Offset
-			changesByProvider = changeMap{}
+			sequence.Groups = append(sequence.Groups, currentGroup)
 		}
 
 		// Find the mapping for the note
@@ -151,21 +158,24 @@ func (s *Sequencer) load(midiFilename, mappingFilename string) error {
 			return fmt.Errorf("note %d has no mapping", e.Note)
 		}
 
-		// Find the provider in the map
-		p, ok := providerMap[m.ProviderID]
-		if !ok {
-			return fmt.Errorf("provider %s does not exist", m.ProviderID)
+		// Effects are started and stopped by cues rather than changes
+		if m.Effect != nil {
+			currentGroup.Cues = append(currentGroup.Cues, &sequencerCue{
+				Note:   e.Note,
+				Start:  e.NoteOn,
+				Effect: m.Effect,
+			})
+			continue
 		}
 
-		// Add the events to the map
-		changesByProvider[p] = append(
-			changesByProvider[p],
-			&registry.Change{
-				GroupID: m.GroupID,
-				LampID:  m.LampID,
-				State:   e.NoteOn,
-			},
-		)
+		// Add the change to the group
+		currentGroup.Changes = append(currentGroup.Changes, &registry.Change{
+			ProviderID: m.ProviderID,
+			GroupID:    m.GroupID,
+			LampID:     m.LampID,
+			Selector:   m.Selector,
+			State:      e.NoteOn,
+		})
 	}
 
 	// Assign the sequence
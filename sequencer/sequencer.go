@@ -1,7 +1,13 @@
 package sequencer
 
 import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lampctl/lampctl/effects"
 	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/util"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -12,6 +18,8 @@ const (
 	commandStop
 )
 
+var errNoSequence = errors.New("no sequence has been loaded")
+
 type sequencerCmd struct {
 	Command int
 	Params  any
@@ -28,33 +36,151 @@ type sequencerCmdLoadParams struct {
 type Sequencer struct {
 	logger     zerolog.Logger
 	registry   *registry.Registry
+	effects    *effects.Engine
 	sequence   *sequencerSequence
+	cueEffects map[int]string
+	watchers   util.Watchers[*TransportEvent]
 	cmdChan    chan *sequencerCmd
 	retChan    chan error
 	closeChan  chan any
 	closedChan chan any
 }
 
-func (s *Sequencer) run() {
+// playGroup applies all of the changes in the group and starts or stops the
+// effects for any cues. The context is cancelled when the sequencer is
+// closed.
+func (s *Sequencer) playGroup(ctx context.Context, g *sequencerGroup) {
+	if len(g.Changes) > 0 {
+		if err := registry.Err(s.registry.ApplyChanges(ctx, g.Changes)); err != nil {
+			s.logger.Error().Msg(err.Error())
+		}
+	}
+	for _, c := range g.Cues {
+		if id, ok := s.cueEffects[c.Note]; ok {
+			s.effects.Stop(id)
+			delete(s.cueEffects, c.Note)
+		}
+		if c.Start {
+			targets := c.Effect.Targets
+			if c.Effect.Selector != nil {
+				targets = append(
+					append([]*registry.Target{}, targets...),
+					s.registry.Select(c.Effect.Selector, "")...,
+				)
+			}
+			e, err := s.effects.Start(c.Effect.Type, targets, c.Effect.Params)
+			if err != nil {
+				s.logger.Error().Msg(err.Error())
+				continue
+			}
+			s.cueEffects[c.Note] = e.ID
+		}
+	}
+}
+
+// record adds an entry with the detail to the history for each lamp that the
+// sequence changes. Lamps controlled by cues are recorded by their effects.
+func (s *Sequencer) record(ctx context.Context, detail string) {
+	var (
+		seen    = map[registry.Target]bool{}
+		targets = []*registry.Target{}
+	)
+	for _, g := range s.sequence.Groups {
+		for _, c := range g.Changes {
+			v := []*registry.Target{{
+				ProviderID: c.ProviderID,
+				GroupID:    c.GroupID,
+				LampID:     c.LampID,
+			}}
+			if c.Selector != nil {
+				v = s.registry.Select(c.Selector, c.ProviderID)
+			}
+			for _, t := range v {
+				if !seen[*t] {
+					seen[*t] = true
+					targets = append(targets, t)
+				}
+			}
+		}
+	}
+	s.registry.RecordHistory(ctx, targets, detail)
+}
+
+// stopCues stops all effects that were started by cues.
+func (s *Sequencer) stopCues() {
+	for note, id := range s.cueEffects {
+		s.effects.Stop(id)
+		delete(s.cueEffects, note)
+	}
+}
+
+// run processes commands and plays the sequence. Changes are applied with
+// ctx, which is cancelled by calling cancel when run returns.
+func (s *Sequencer) run(ctx context.Context, cancel context.CancelFunc) {
 	defer close(s.closedChan)
+	defer cancel()
 	defer s.logger.Info().Msg("sequencer stopped")
 	s.logger.Info().Msg("sequencer started")
 	var (
-	//...
+		timer     = time.NewTimer(0)
+		timerChan <-chan time.Time
+		startTime time.Time
 	)
+	<-timer.C
+	defer timer.Stop()
+	stop := func() {
+		if timerChan != nil {
+			timer.Stop()
+			timerChan = nil
+			s.record(ctx, "stopped sequence")
+			s.notify(TransportStopped)
+		}
+		s.stopCues()
+	}
+	schedule := func() {
+		if s.sequence.GroupIndex >= len(s.sequence.Groups) {
+			s.logger.Info().Msg("sequence finished")
+			timerChan = nil
+			s.record(ctx, "finished sequence")
+			s.notify(TransportFinished)
+			return
+		}
+		g := s.sequence.Groups[s.sequence.GroupIndex]
+		timer.Reset(time.Until(startTime.Add(g.Offset)))
+		timerChan = timer.C
+	}
 	for {
 		select {
 		case c := <-s.cmdChan:
 			switch c.Command {
 			case commandLoad:
+				stop()
 				p := c.Params.(*sequencerCmdLoadParams)
-				s.retChan <- s.load(p.MidiFilename, p.MappingFilename)
+				err := s.load(p.MidiFilename, p.MappingFilename)
+				s.retChan <- err
+				if err == nil {
+					s.notify(TransportLoaded)
+				}
 			case commandPlay:
-				break
+				stop()
+				if s.sequence == nil {
+					s.logger.Error().Msg(errNoSequence.Error())
+					break
+				}
+				s.sequence.GroupIndex = 0
+				startTime = time.Now()
+				s.record(ctx, "started sequence")
+				s.notify(TransportPlaying)
+				schedule()
 			case commandStop:
-				break
+				stop()
 			}
+		case <-timerChan:
+			s.playGroup(ctx, s.sequence.Groups[s.sequence.GroupIndex])
+			s.sequence.GroupIndex++
+			schedule()
 		case <-s.closeChan:
+			stop()
 			return
 		}
 	}
@@ -65,12 +191,19 @@ func New(cfg *Config) *Sequencer {
 	s := &Sequencer{
 		logger:     log.With().Str("package", "sequencer").Logger(),
 		registry:   cfg.Registry,
+		effects:    cfg.Effects,
+		cueEffects: make(map[int]string),
 		cmdChan:    make(chan *sequencerCmd),
 		retChan:    make(chan error),
 		closeChan:  make(chan any),
 		closedChan: make(chan any),
 	}
-	go s.run()
+	ctx, cancel := context.WithCancel(registry.WithSource(
+		context.Background(),
+		registry.SourceSequencer,
+		"",
+	))
+	go s.run(ctx, cancel)
 	return s
 }
 
@@ -0,0 +1,24 @@
+package sequencer
+
+const (
+	TransportLoaded   = "loaded"
+	TransportPlaying  = "playing"
+	TransportStopped  = "stopped"
+	TransportFinished = "finished"
+)
+
+// TransportEvent indicates that a sequence was loaded or that playback
+// started, stopped, or reached the end of the sequence.
+type TransportEvent struct {
+	State string `json:"state"`
+}
+
+// Watch registers a function that is invoked whenever the state of playback
+// changes.
+func (s *Sequencer) Watch(fn func(e *TransportEvent)) {
+	s.watchers.Add(fn)
+}
+
+func (s *Sequencer) notify(state string) {
+	s.watchers.Notify(&TransportEvent{State: state})
+}
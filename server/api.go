@@ -2,27 +2,98 @@ package server
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lampctl/lampctl/automation"
+	"github.com/lampctl/lampctl/effects"
+	effects_db "github.com/lampctl/lampctl/effects/db"
 	"github.com/lampctl/lampctl/registry"
+	registry_db "github.com/lampctl/lampctl/registry/db"
+	"github.com/lampctl/lampctl/scheduler"
 )
 
 type providerJSON struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	Enabled bool             `json:"enabled"`
+	Timeout int64            `json:"timeout"`
+	Health  *registry.Health `json:"health"`
+}
+
+func (s *Server) api_apply_POST(c *gin.Context) {
+	v := []*registry.Change{}
+	if err := c.ShouldBindJSON(&v); err != nil {
+		panic(err)
+	}
+	results := s.ApplyChanges(c.Request.Context(), v)
+	c.JSON(resultsStatus(results), results)
 }
 
 func (s *Server) api_providers_GET(c *gin.Context) {
-	response := []*providerJSON{}
+	var (
+		response = []*providerJSON{}
+		enabled  = map[string]bool{}
+	)
 	for _, p := range s.registry.Providers() {
 		response = append(response, &providerJSON{
-			ID:   p.ID(),
-			Name: p.Name(),
+			ID:      p.ID(),
+			Name:    p.Name(),
+			Enabled: true,
+			Timeout: s.registry.Timeout(p.ID()).Milliseconds(),
+			Health:  registry.ProviderHealth(p),
+		})
+		enabled[p.ID()] = true
+	}
+	for _, d := range s.registry.Definitions() {
+		if enabled[d.ID] {
+			continue
+		}
+		response = append(response, &providerJSON{
+			ID:      d.ID,
+			Name:    d.Name,
+			Timeout: s.registry.Timeout(d.ID).Milliseconds(),
+			Health: &registry.Health{
+				Status: registry.HealthDisabled,
+			},
 		})
 	}
 	c.JSON(http.StatusOK, response)
 }
 
+func (s *Server) api_providers_id_enable_POST(c *gin.Context) {
+	if err := s.registry.Enable(c.Param("id")); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_providers_id_disable_POST(c *gin.Context) {
+	if err := s.registry.Disable(c.Param("id")); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+type providerTimeoutJSON struct {
+	Timeout int64 `json:"timeout"`
+}
+
+func (s *Server) api_providers_id_timeout_POST(c *gin.Context) {
+	v := &providerTimeoutJSON{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.registry.SetTimeout(
+		c.Param("id"),
+		time.Duration(v.Timeout)*time.Millisecond,
+	); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
 type providerMetaJSON struct {
 	Groups []*registry.Group `json:"groups"`
 	Lamps  []*registry.Lamp  `json:"lamps"`
@@ -35,7 +106,7 @@ func (s *Server) api_providers_id_GET(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, &providerMetaJSON{
 		Groups: p.Groups(),
-		Lamps:  p.Lamps(),
+		Lamps:  s.registry.Lamps(p),
 	})
 }
 
@@ -44,10 +115,11 @@ func (s *Server) api_providers_id_apply_POST(c *gin.Context) {
 	if err := c.ShouldBindJSON(&v); err != nil {
 		panic(err)
 	}
-	if err := s.Apply(c.Param("id"), v); err != nil {
+	results, err := s.Apply(c.Request.Context(), c.Param("id"), v)
+	if err != nil {
 		panic(err)
 	}
-	c.JSON(http.StatusOK, gin.H{})
+	c.JSON(resultsStatus(results), results)
 }
 
 func (s *Server) api_providers_id_apply_all_POST(c *gin.Context) {
@@ -55,11 +127,7 @@ func (s *Server) api_providers_id_apply_all_POST(c *gin.Context) {
 	if err := c.ShouldBindJSON(&v); err != nil {
 		panic(err)
 	}
-	p, err := s.registry.GetProvider(c.Param("id"))
-	if err != nil {
-		panic(err)
-	}
-	if err := p.ApplyToAll(v); err != nil {
+	if err := s.registry.ApplyToAll(c.Request.Context(), c.Param("id"), v); err != nil {
 		panic(err)
 	}
 	c.JSON(http.StatusOK, gin.H{})
@@ -99,3 +167,653 @@ func (s *Server) api_sequencer_stop_POST(c *gin.Context) {
 	s.sequencer.Stop()
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func (s *Server) api_effects_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.effects.Effects())
+}
+
+type effectStartJSON struct {
+	Type     string             `json:"type"`
+	Targets  []*registry.Target `json:"targets"`
+	Selector *registry.Selector `json:"selector"`
+	Params   *effects.Params    `json:"params"`
+}
+
+func (s *Server) api_effects_POST(c *gin.Context) {
+	v := &effectStartJSON{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if v.Selector != nil {
+		v.Targets = append(v.Targets, s.registry.Select(v.Selector, "")...)
+	}
+	e, err := s.effects.Start(v.Type, v.Targets, v.Params)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+func (s *Server) api_effects_types_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.effects.Types())
+}
+
+func (s *Server) api_effects_id_DELETE(c *gin.Context) {
+	if err := s.effects.Stop(c.Param("id")); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_scripts_GET(c *gin.Context) {
+	scripts, err := s.effects.Scripts()
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, scripts)
+}
+
+func (s *Server) api_scripts_POST(c *gin.Context) {
+	v := &effects_db.Script{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = 0
+	if err := s.effects.SaveScript(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scripts_id_GET(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	v, err := s.effects.Script(id)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scripts_id_POST(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.effects.Script(id); err != nil {
+		panic(err)
+	}
+	v := &effects_db.Script{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = id
+	if err := s.effects.SaveScript(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scripts_id_DELETE(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.effects.DeleteScript(id); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_plugins_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.plugins.Plugins())
+}
+
+func (s *Server) api_plugins_id_enable_POST(c *gin.Context) {
+	if err := s.plugins.SetEnabled(c.Param("id"), true); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_plugins_id_disable_POST(c *gin.Context) {
+	if err := s.plugins.SetEnabled(c.Param("id"), false); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_tags_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.registry.Tags())
+}
+
+func (s *Server) api_tags_POST(c *gin.Context) {
+	v := &registry_db.Tag{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.registry.AddTag(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_tags_DELETE(c *gin.Context) {
+	if err := s.registry.RemoveTag(&registry_db.Tag{
+		ProviderID: c.Query("provider_id"),
+		GroupID:    c.Query("group_id"),
+		LampID:     c.Query("lamp_id"),
+		Name:       c.Query("tag"),
+	}); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_metadata_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.registry.Metadata())
+}
+
+func (s *Server) api_metadata_POST(c *gin.Context) {
+	v := &registry_db.Metadata{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.registry.SetMetadata(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_metadata_DELETE(c *gin.Context) {
+	if err := s.registry.DeleteMetadata(
+		c.Query("provider_id"),
+		c.Query("group_id"),
+		c.Query("lamp_id"),
+	); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+type historyQueryJSON struct {
+	ProviderID string    `form:"provider_id"`
+	GroupID    string    `form:"group_id"`
+	LampID     string    `form:"lamp_id"`
+	Source     string    `form:"source"`
+	Since      time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until      time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit      int       `form:"limit"`
+}
+
+func (s *Server) api_history_GET(c *gin.Context) {
+	v := &historyQueryJSON{}
+	if err := c.ShouldBindQuery(v); err != nil {
+		panic(err)
+	}
+	entries, err := s.registry.History(&registry.HistoryQuery{
+		ProviderID: v.ProviderID,
+		GroupID:    v.GroupID,
+		LampID:     v.LampID,
+		Source:     v.Source,
+		Since:      v.Since,
+		Until:      v.Until,
+		Limit:      v.Limit,
+	})
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+type undoQueryJSON struct {
+	Scope string `form:"scope"`
+}
+
+// undoGlobal determines whether the request applies to changes made by
+// anyone rather than only the client's own session.
+func undoGlobal(c *gin.Context) bool {
+	v := &undoQueryJSON{}
+	if err := c.ShouldBindQuery(v); err != nil {
+		panic(err)
+	}
+	switch v.Scope {
+	case "", undoScopeSession:
+		return false
+	case undoScopeGlobal:
+		return true
+	}
+	panic(registry.Rejectf("invalid scope %q", v.Scope))
+}
+
+func (s *Server) api_undo_POST(c *gin.Context) {
+	results, err := s.registry.Undo(c.Request.Context(), undoGlobal(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(resultsStatus(results), results)
+}
+
+func (s *Server) api_redo_POST(c *gin.Context) {
+	results, err := s.registry.Redo(c.Request.Context(), undoGlobal(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(resultsStatus(results), results)
+}
+
+func (s *Server) api_enforced_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.registry.Enforced())
+}
+
+func (s *Server) api_enforced_POST(c *gin.Context) {
+	v := &registry.Target{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.registry.SetEnforced(v, true); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_enforced_DELETE(c *gin.Context) {
+	if err := s.registry.SetEnforced(&registry.Target{
+		ProviderID: c.Query("provider_id"),
+		GroupID:    c.Query("group_id"),
+		LampID:     c.Query("lamp_id"),
+	}, false); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_power_on_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.registry.PowerOn())
+}
+
+func (s *Server) api_power_on_POST(c *gin.Context) {
+	v := &registry_db.PowerOn{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.registry.SetPowerOn(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_power_on_DELETE(c *gin.Context) {
+	if err := s.registry.DeletePowerOn(
+		c.Query("provider_id"),
+		c.Query("group_id"),
+		c.Query("lamp_id"),
+	); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_timers_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.registry.Timers())
+}
+
+func (s *Server) api_timers_DELETE(c *gin.Context) {
+	if err := s.registry.CancelTimer(
+		c.Query("provider_id"),
+		c.Query("group_id"),
+		c.Query("lamp_id"),
+	); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_max_on_GET(c *gin.Context) {
+	c.JSON(http.StatusOK, s.registry.MaxOn())
+}
+
+func (s *Server) api_max_on_POST(c *gin.Context) {
+	v := &registry_db.MaxOn{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.registry.SetMaxOn(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_max_on_DELETE(c *gin.Context) {
+	if err := s.registry.DeleteMaxOn(
+		c.Query("provider_id"),
+		c.Query("group_id"),
+		c.Query("lamp_id"),
+	); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_scenes_GET(c *gin.Context) {
+	v, err := s.registry.Scenes()
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scenes_POST(c *gin.Context) {
+	v := &registry.Scene{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = 0
+	if err := s.registry.SaveScene(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scenes_id_GET(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	v, err := s.registry.Scene(id)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scenes_id_POST(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.registry.Scene(id); err != nil {
+		panic(err)
+	}
+	v := &registry.Scene{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = id
+	if err := s.registry.SaveScene(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_scenes_id_DELETE(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.registry.DeleteScene(id); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_scenes_id_apply_POST(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	results, err := s.registry.ApplyScene(c.Request.Context(), id)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(resultsStatus(results), results)
+}
+
+func (s *Server) api_schedules_GET(c *gin.Context) {
+	jobs, err := s.scheduler.Jobs()
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+func (s *Server) api_schedules_POST(c *gin.Context) {
+	v := &scheduler.Job{Enabled: true}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = 0
+	if err := s.scheduler.SaveJob(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_schedules_id_GET(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	v, err := s.scheduler.Job(id)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_schedules_id_POST(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.scheduler.Job(id); err != nil {
+		panic(err)
+	}
+	v := &scheduler.Job{Enabled: true}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = id
+	if err := s.scheduler.SaveJob(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_schedules_id_DELETE(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.scheduler.DeleteJob(id); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_schedules_id_run_POST(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.scheduler.RunJob(id); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+type previewQueryJSON struct {
+	Count int `form:"count"`
+}
+
+// preview returns the next runs of the job using the count from the query.
+func (s *Server) preview(c *gin.Context, j *scheduler.Job) {
+	q := &previewQueryJSON{}
+	if err := c.ShouldBindQuery(q); err != nil {
+		panic(err)
+	}
+	times, err := s.scheduler.Preview(j, time.Now(), q.Count)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, times)
+}
+
+func (s *Server) api_schedules_preview_POST(c *gin.Context) {
+	v := &scheduler.Job{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	s.preview(c, v)
+}
+
+func (s *Server) api_schedules_id_preview_GET(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	v, err := s.scheduler.Job(id)
+	if err != nil {
+		panic(err)
+	}
+	s.preview(c, v)
+}
+
+func (s *Server) api_rules_GET(c *gin.Context) {
+	rules, err := s.automation.Rules()
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (s *Server) api_rules_POST(c *gin.Context) {
+	v := &automation.Rule{Enabled: true}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = 0
+	if err := s.automation.SaveRule(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_rules_id_GET(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	v, err := s.automation.Rule(id)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_rules_id_POST(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.automation.Rule(id); err != nil {
+		panic(err)
+	}
+	v := &automation.Rule{Enabled: true}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	v.ID = id
+	if err := s.automation.SaveRule(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_rules_id_DELETE(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.automation.DeleteRule(id); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) api_webhooks_name_POST(c *gin.Context) {
+	if err := s.automation.Webhook(c.Param("name")); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// presenceStatus returns the status of the presence simulation.
+func (s *Server) presenceStatus(c *gin.Context) {
+	v, err := s.presence.Status()
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_presence_GET(c *gin.Context) {
+	s.presenceStatus(c)
+}
+
+func (s *Server) api_presence_POST(c *gin.Context) {
+	v, err := s.presence.Settings()
+	if err != nil {
+		panic(err)
+	}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.presence.SetSettings(v); err != nil {
+		panic(err)
+	}
+	s.presenceStatus(c)
+}
+
+func (s *Server) api_presence_enable_POST(c *gin.Context) {
+	if err := s.presence.Enable(); err != nil {
+		panic(err)
+	}
+	s.presenceStatus(c)
+}
+
+func (s *Server) api_presence_disable_POST(c *gin.Context) {
+	if err := s.presence.Disable(); err != nil {
+		panic(err)
+	}
+	s.presenceStatus(c)
+}
+
+func (s *Server) api_location_GET(c *gin.Context) {
+	l := s.scheduler.Location()
+	if l == nil {
+		panic(scheduler.ErrNoLocation)
+	}
+	c.JSON(http.StatusOK, l)
+}
+
+func (s *Server) api_location_POST(c *gin.Context) {
+	v := &scheduler.Location{}
+	if err := c.ShouldBindJSON(v); err != nil {
+		panic(err)
+	}
+	if err := s.scheduler.SetLocation(v); err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) api_select_GET(c *gin.Context) {
+	v, err := registry.ParseSelector(c.Query("selector"))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, s.registry.Select(v, ""))
+}
@@ -1,14 +1,24 @@
 package server
 
 import (
+	"github.com/lampctl/lampctl/automation"
+	"github.com/lampctl/lampctl/effects"
+	"github.com/lampctl/lampctl/plugins"
+	"github.com/lampctl/lampctl/presence"
 	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
 	"github.com/lampctl/lampctl/sequencer"
 )
 
 // Config provides the configuration for the web server.
 type Config struct {
-	Addr      string
-	Debug     bool
-	Registry  *registry.Registry
-	Sequencer *sequencer.Sequencer
+	Addr       string
+	Debug      bool
+	Registry   *registry.Registry
+	Effects    *effects.Engine
+	Sequencer  *sequencer.Sequencer
+	Scheduler  *scheduler.Scheduler
+	Automation *automation.Automation
+	Presence   *presence.Presence
+	Plugins    *plugins.Manager
 }
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lampctl/lampctl/automation"
+	"github.com/lampctl/lampctl/effects"
+	"github.com/lampctl/lampctl/hue"
+	"github.com/lampctl/lampctl/plugins"
+	"github.com/lampctl/lampctl/presence"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
+	"github.com/lampctl/lampctl/util"
+	"gorm.io/gorm"
+)
+
+// errorStatuses maps errors returned by other packages to HTTP status codes;
+// anything not listed here results in a 500.
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	{registry.ErrInvalidProvider, http.StatusNotFound},
+	{registry.ErrProviderUnavailable, http.StatusServiceUnavailable},
+	{registry.ErrInvalidTimeout, http.StatusBadRequest},
+	{registry.ErrInvalidScene, http.StatusNotFound},
+	{registry.ErrNothingToUndo, http.StatusConflict},
+	{registry.ErrNothingToRedo, http.StatusConflict},
+	{registry.ErrInvalidTimer, http.StatusNotFound},
+	{effects.ErrInvalidEffect, http.StatusNotFound},
+	{effects.ErrInvalidScript, http.StatusNotFound},
+	{effects.ErrInvalidEffectType, http.StatusBadRequest},
+	{effects.ErrInvalidDirection, http.StatusBadRequest},
+	{effects.ErrNoTargets, http.StatusBadRequest},
+	{effects.ErrScriptCompile, http.StatusBadRequest},
+	{automation.ErrInvalidRule, http.StatusNotFound},
+	{automation.ErrInvalidTriggerType, http.StatusBadRequest},
+	{automation.ErrInvalidCondition, http.StatusBadRequest},
+	{automation.ErrInvalidWebhook, http.StatusNotFound},
+	{scheduler.ErrInvalidJob, http.StatusNotFound},
+	{scheduler.ErrInvalidActionType, http.StatusBadRequest},
+	{scheduler.ErrNoLocation, http.StatusConflict},
+	{presence.ErrNoPattern, http.StatusConflict},
+	{hue.ErrInvalidBridge, http.StatusNotFound},
+	{plugins.ErrInvalidPlugin, http.StatusNotFound},
+	{util.ErrInvalidTime, http.StatusBadRequest},
+	{util.ErrInvalidTimezone, http.StatusBadRequest},
+	{gorm.ErrRecordNotFound, http.StatusNotFound},
+	{errUnsupportedVersion, http.StatusBadRequest},
+	{errInvalidAction, http.StatusBadRequest},
+	{errInvalidMessageType, http.StatusBadRequest},
+	{strconv.ErrSyntax, http.StatusBadRequest},
+	{strconv.ErrRange, http.StatusBadRequest},
+	{io.EOF, http.StatusBadRequest},
+	{io.ErrUnexpectedEOF, http.StatusBadRequest},
+}
+
+// errorStatus determines the HTTP status code for an error raised while
+// handling a request.
+func errorStatus(err error) int {
+	for _, v := range errorStatuses {
+		if errors.Is(err, v.err) {
+			return v.status
+		}
+	}
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		timeErr   *time.ParseError
+	)
+	if errors.As(err, &syntaxErr) ||
+		errors.As(err, &typeErr) ||
+		errors.As(err, &timeErr) ||
+		registry.IsRejection(err) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// resultsStatus determines the HTTP status code for the results of applying
+// a list of changes: 200 if all were applied, 207 if only some were, 400 if
+// all were rejected, and 502 if any failed and none were applied.
+func resultsStatus(results []*registry.Result) int {
+	var applied, rejected, failed int
+	for _, r := range results {
+		switch r.Status {
+		case registry.ResultApplied:
+			applied++
+		case registry.ResultRejected:
+			rejected++
+		default:
+			failed++
+		}
+	}
+	switch {
+	case rejected == 0 && failed == 0:
+		return http.StatusOK
+	case applied > 0:
+		return http.StatusMultiStatus
+	case failed == 0:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lampctl/lampctl/automation"
+	"github.com/lampctl/lampctl/presence"
+	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
+	"github.com/lampctl/lampctl/sequencer"
+	"github.com/nathan-osman/go-herald"
+)
+
+const (
+	eventTypeSequencer  = "sequencer"
+	eventTypeSchedule   = "schedule"
+	eventTypeAutomation = "automation"
+	eventTypePresence   = "presence"
+
+	// eventTypeReset is sent to a client resuming from an event that is no
+	// longer in the history, indicating that it should reload everything
+	eventTypeReset = "reset"
+
+	// eventHistorySize is the number of events kept for clients resuming
+	// with Last-Event-ID
+	eventHistorySize = 1000
+
+	// eventBufferSize is the number of events that may be queued for a
+	// client before it is disconnected for being too slow
+	eventBufferSize = 100
+
+	// eventKeepAliveInterval determines how often a comment is sent to keep
+	// idle connections open
+	eventKeepAliveInterval = 15 * time.Second
+)
+
+// serverEvent is sent to websocket and SSE clients. Events without a
+// provider are sent regardless of any provider filter.
+type serverEvent struct {
+	ID         uint64
+	Type       string
+	ProviderID string
+	Data       interface{}
+}
+
+// eventHub assigns IDs to events, keeps a history of recent events, and
+// delivers them to each SSE listener.
+type eventHub struct {
+	mutex     sync.Mutex
+	nextID    uint64
+	history   []*serverEvent
+	listeners map[chan *serverEvent]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		nextID:    1,
+		listeners: make(map[chan *serverEvent]bool),
+	}
+}
+
+func (h *eventHub) publish(eventType, providerID string, data interface{}) *serverEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e := &serverEvent{
+		ID:         h.nextID,
+		Type:       eventType,
+		ProviderID: providerID,
+		Data:       data,
+	}
+	h.nextID++
+	h.history = append(h.history, e)
+	if len(h.history) > eventHistorySize {
+		h.history = h.history[len(h.history)-eventHistorySize:]
+	}
+	for ch := range h.listeners {
+		select {
+		case ch <- e:
+		default:
+			delete(h.listeners, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// listen registers a new listener. If lastID is not zero, the events after
+// it are returned; complete is false if some of them are no longer in the
+// history.
+func (h *eventHub) listen(lastID uint64) (ch chan *serverEvent, missed []*serverEvent, complete bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ch = make(chan *serverEvent, eventBufferSize)
+	h.listeners[ch] = true
+	if lastID == 0 {
+		return ch, nil, true
+	}
+	if lastID >= h.nextID ||
+		(len(h.history) > 0 && lastID+1 < h.history[0].ID) {
+		return ch, nil, false
+	}
+	for _, e := range h.history {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return ch, missed, true
+}
+
+func (h *eventHub) unlisten(ch chan *serverEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.listeners[ch]; ok {
+		delete(h.listeners, ch)
+		close(ch)
+	}
+}
+
+// broadcast records the event and sends it to each websocket client that is
+// subscribed to the provider. SSE listeners receive it from the hub.
+func (s *Server) broadcast(eventType, providerID string, data interface{}) {
+	s.events.publish(eventType, providerID, data)
+	clients := []*herald.Client{}
+	for _, c := range s.herald.Clients() {
+		if providerID == "" || c.Data.(*wsClient).subscribed(providerID) {
+			clients = append(clients, c)
+		}
+	}
+	if len(clients) == 0 {
+		return
+	}
+	msg, err := herald.NewMessage(eventType, data)
+	if err != nil {
+		s.logger.Error().Msg(err.Error())
+		return
+	}
+	s.herald.Send(msg, clients)
+}
+
+func (s *Server) registryEventHandler(e *registry.Event) {
+	s.broadcast(e.Type, e.ProviderID, e)
+}
+
+func (s *Server) sequencerEventHandler(e *sequencer.TransportEvent) {
+	s.broadcast(eventTypeSequencer, "", e)
+}
+
+func (s *Server) schedulerEventHandler(e *scheduler.Event) {
+	s.broadcast(eventTypeSchedule, "", e)
+}
+
+func (s *Server) automationEventHandler(e *automation.Event) {
+	s.broadcast(eventTypeAutomation, "", e)
+}
+
+func (s *Server) presenceEventHandler(e *presence.Event) {
+	s.broadcast(eventTypePresence, "", e)
+}
+
+// queryList combines repeated and comma-separated values of a query
+// parameter into a set, which is nil if the parameter was not provided.
+func queryList(c *gin.Context, key string) map[string]bool {
+	var v map[string]bool
+	for _, q := range c.QueryArray(key) {
+		for _, item := range strings.Split(q, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				if v == nil {
+					v = make(map[string]bool)
+				}
+				v[item] = true
+			}
+		}
+	}
+	return v
+}
+
+// writeEvent writes a single event in the SSE format.
+func writeEvent(c *gin.Context, e *serverEvent) error {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	if e.ID != 0 {
+		if _, err := fmt.Fprintf(c.Writer, "id: %d\n", e.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// api_events_GET streams events using Server-Sent Events. The type and
+// provider_id query parameters limit the events sent. Clients that reconnect
+// with the Last-Event-ID header (or the last_event_id query parameter) are
+// sent the events they missed, or a reset event if they are no longer
+// available.
+func (s *Server) api_events_GET(c *gin.Context) {
+	var (
+		types     = queryList(c, "type")
+		providers = queryList(c, "provider_id")
+		lastIDStr = c.GetHeader("Last-Event-ID")
+		lastID    uint64
+	)
+	if lastIDStr == "" {
+		lastIDStr = c.Query("last_event_id")
+	}
+	if lastIDStr != "" {
+		v, err := strconv.ParseUint(lastIDStr, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		lastID = v
+	}
+	match := func(e *serverEvent) bool {
+		return (types == nil || types[e.Type]) &&
+			(providers == nil || e.ProviderID == "" || providers[e.ProviderID])
+	}
+	ch, missed, complete := s.events.listen(lastID)
+	defer s.events.unlisten(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	if !complete {
+		if err := writeEvent(c, &serverEvent{
+			Type: eventTypeReset,
+			Data: struct{}{},
+		}); err != nil {
+			return
+		}
+	}
+	for _, e := range missed {
+		if match(e) {
+			if err := writeEvent(c, e); err != nil {
+				return
+			}
+		}
+	}
+	ticker := time.NewTicker(eventKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !match(e) {
+				continue
+			}
+			if err := writeEvent(c, e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
+	"github.com/lampctl/lampctl/automation"
+	"github.com/lampctl/lampctl/effects"
+	"github.com/lampctl/lampctl/plugins"
+	"github.com/lampctl/lampctl/presence"
 	"github.com/lampctl/lampctl/registry"
+	"github.com/lampctl/lampctl/scheduler"
 	"github.com/lampctl/lampctl/sequencer"
 	"github.com/lampctl/lampctl/ui"
 	"github.com/nathan-osman/go-herald"
@@ -17,33 +23,28 @@ import (
 
 // Server provides an HTTP interface for interacting with lamps.
 type Server struct {
-	server    http.Server
-	herald    *herald.Herald
-	logger    zerolog.Logger
-	registry  *registry.Registry
-	sequencer *sequencer.Sequencer
+	wsSessions  int64
+	ctx         context.Context
+	cancel      context.CancelFunc
+	server      http.Server
+	router      atomic.Value
+	herald      *herald.Herald
+	logger      zerolog.Logger
+	registry    *registry.Registry
+	effects     *effects.Engine
+	sequencer   *sequencer.Sequencer
+	scheduler   *scheduler.Scheduler
+	automation  *automation.Automation
+	presence    *presence.Presence
+	plugins     *plugins.Manager
+	events      *eventHub
+	unsubscribe func()
 }
 
-func New(cfg *Config) (*Server, error) {
-
-	// Switch to release mode if DEBUG is not set
-	if !cfg.Debug {
-		gin.SetMode(gin.ReleaseMode)
-	}
-
-	var (
-		r = gin.New()
-		s = &Server{
-			server: http.Server{
-				Addr:    cfg.Addr,
-				Handler: r,
-			},
-			herald:    herald.New(),
-			logger:    log.With().Str("package", "server").Logger(),
-			registry:  cfg.Registry,
-			sequencer: cfg.Sequencer,
-		}
-	)
+// newRouter creates the router for all static files and API routes,
+// including those registered by each of the providers.
+func (s *Server) newRouter() (*gin.Engine, error) {
+	r := gin.New()
 
 	// Serve the static files
 	r.Use(static.Serve("/", ui.EmbedFileSystem{FileSystem: http.FS(ui.Content)}))
@@ -54,25 +55,117 @@ func New(cfg *Config) (*Server, error) {
 	// Attempt to handle panic() calls within API routes by converting them
 	// into proper JSON responses
 	api.Use(gin.CustomRecovery(func(c *gin.Context, i interface{}) {
-		var message string
+		var (
+			message string
+			status  = http.StatusInternalServerError
+		)
 		switch v := i.(type) {
 		case error:
 			message = v.Error()
+			status = errorStatus(v)
 		case string:
 			message = v
 		default:
 			message = "an unknown error has occurred"
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(status, gin.H{
 			"error": message,
 		})
 	}))
 
+	// Attribute changes made through the API to the client's address; the
+	// X-Session-ID header allows clients to keep separate undo stacks
+	api.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(registry.WithSession(
+			c.Request.Context(),
+			registry.SourceREST,
+			c.ClientIP(),
+			c.GetHeader("X-Session-ID"),
+		))
+	})
+
+	// Add the route for applying changes to lamps in any provider
+	api.POST("/apply", s.api_apply_POST)
+
+	// Add the routes for tagging lamps and resolving selectors
+	api.GET("/tags", s.api_tags_GET)
+	api.POST("/tags", s.api_tags_POST)
+	api.DELETE("/tags", s.api_tags_DELETE)
+	api.GET("/select", s.api_select_GET)
+
+	// Add the routes for user-provided lamp metadata
+	api.GET("/metadata", s.api_metadata_GET)
+	api.POST("/metadata", s.api_metadata_POST)
+	api.DELETE("/metadata", s.api_metadata_DELETE)
+
+	// Add the route for querying the history of changes
+	api.GET("/history", s.api_history_GET)
+
+	// Add the routes for undoing and redoing changes
+	api.POST("/undo", s.api_undo_POST)
+	api.POST("/redo", s.api_redo_POST)
+
+	// Add the routes for enforcing the state of lamps
+	api.GET("/enforced", s.api_enforced_GET)
+	api.POST("/enforced", s.api_enforced_POST)
+	api.DELETE("/enforced", s.api_enforced_DELETE)
+
+	// Add the routes for lamp power-on behavior
+	api.GET("/power-on", s.api_power_on_GET)
+	api.POST("/power-on", s.api_power_on_POST)
+	api.DELETE("/power-on", s.api_power_on_DELETE)
+
+	// Add the routes for pending timers and maximum on-times
+	api.GET("/timers", s.api_timers_GET)
+	api.DELETE("/timers", s.api_timers_DELETE)
+	api.GET("/max-on", s.api_max_on_GET)
+	api.POST("/max-on", s.api_max_on_POST)
+	api.DELETE("/max-on", s.api_max_on_DELETE)
+
+	// Add the routes for managing and applying scenes
+	api.GET("/scenes", s.api_scenes_GET)
+	api.POST("/scenes", s.api_scenes_POST)
+	api.GET("/scenes/:id", s.api_scenes_id_GET)
+	api.POST("/scenes/:id", s.api_scenes_id_POST)
+	api.DELETE("/scenes/:id", s.api_scenes_id_DELETE)
+	api.POST("/scenes/:id/apply", s.api_scenes_id_apply_POST)
+
+	// Add the routes for managing scheduled jobs
+	api.GET("/schedules", s.api_schedules_GET)
+	api.POST("/schedules", s.api_schedules_POST)
+	api.POST("/schedules/preview", s.api_schedules_preview_POST)
+	api.GET("/schedules/:id", s.api_schedules_id_GET)
+	api.POST("/schedules/:id", s.api_schedules_id_POST)
+	api.DELETE("/schedules/:id", s.api_schedules_id_DELETE)
+	api.GET("/schedules/:id/preview", s.api_schedules_id_preview_GET)
+	api.POST("/schedules/:id/run", s.api_schedules_id_run_POST)
+
+	// Add the routes for managing automation rules and calling webhooks
+	api.GET("/rules", s.api_rules_GET)
+	api.POST("/rules", s.api_rules_POST)
+	api.GET("/rules/:id", s.api_rules_id_GET)
+	api.POST("/rules/:id", s.api_rules_id_POST)
+	api.DELETE("/rules/:id", s.api_rules_id_DELETE)
+	api.POST("/webhooks/:name", s.api_webhooks_name_POST)
+
+	// Add the routes for the presence simulation
+	api.GET("/presence", s.api_presence_GET)
+	api.POST("/presence", s.api_presence_POST)
+	api.POST("/presence/enable", s.api_presence_enable_POST)
+	api.POST("/presence/disable", s.api_presence_disable_POST)
+
+	// Add the routes for the location used by solar triggers
+	api.GET("/location", s.api_location_GET)
+	api.POST("/location", s.api_location_POST)
+
 	// Add the provider API routes
 	api.GET("/providers", s.api_providers_GET)
 	api.GET("/providers/:id", s.api_providers_id_GET)
 	api.POST("/providers/:id/apply", s.api_providers_id_apply_POST)
 	api.POST("/providers/:id/apply/all", s.api_providers_id_apply_all_POST)
+	api.POST("/providers/:id/enable", s.api_providers_id_enable_POST)
+	api.POST("/providers/:id/disable", s.api_providers_id_disable_POST)
+	api.POST("/providers/:id/timeout", s.api_providers_id_timeout_POST)
 
 	// Add the sequencer API routes
 	api.GET("/sequencer", s.api_sequencer_GET)
@@ -80,8 +173,27 @@ func New(cfg *Config) (*Server, error) {
 	api.POST("/sequencer/play", s.api_sequencer_play_POST)
 	api.POST("/sequencer/stop", s.api_sequencer_stop_POST)
 
-	// Special route for websocket connections
+	// Add the effects API routes
+	api.GET("/effects", s.api_effects_GET)
+	api.POST("/effects", s.api_effects_POST)
+	api.GET("/effects/types", s.api_effects_types_GET)
+	api.DELETE("/effects/:id", s.api_effects_id_DELETE)
+
+	// Add the effect script API routes
+	api.GET("/scripts", s.api_scripts_GET)
+	api.POST("/scripts", s.api_scripts_POST)
+	api.GET("/scripts/:id", s.api_scripts_id_GET)
+	api.POST("/scripts/:id", s.api_scripts_id_POST)
+	api.DELETE("/scripts/:id", s.api_scripts_id_DELETE)
+
+	// Add the plugin API routes
+	api.GET("/plugins", s.api_plugins_GET)
+	api.POST("/plugins/:id/enable", s.api_plugins_id_enable_POST)
+	api.POST("/plugins/:id/disable", s.api_plugins_id_disable_POST)
+
+	// Special routes for websocket connections and server-sent events
 	api.GET("/ws", s.api_ws_GET)
+	api.GET("/events", s.api_events_GET)
 
 	// Add the API routes from each individual provider
 	for _, p := range s.registry.Providers() {
@@ -97,10 +209,72 @@ func New(cfg *Config) (*Server, error) {
 		c.Abort()
 	})
 
+	return r, nil
+}
+
+// rebuild replaces the router, allowing routes for providers that have
+// become available to be served.
+func (s *Server) rebuild() {
+	r, err := s.newRouter()
+	if err != nil {
+		s.logger.Error().Msg(err.Error())
+		return
+	}
+	s.router.Store(r)
+}
+
+// ServeHTTP dispatches the request to the current router.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.Load().(*gin.Engine).ServeHTTP(w, r)
+}
+
+func New(cfg *Config) (*Server, error) {
+
+	// Switch to release mode if DEBUG is not set
+	if !cfg.Debug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		ctx:    ctx,
+		cancel: cancel,
+		server: http.Server{
+			Addr: cfg.Addr,
+		},
+		herald:     herald.New(),
+		logger:     log.With().Str("package", "server").Logger(),
+		registry:   cfg.Registry,
+		effects:    cfg.Effects,
+		sequencer:  cfg.Sequencer,
+		scheduler:  cfg.Scheduler,
+		automation: cfg.Automation,
+		presence:   cfg.Presence,
+		plugins:    cfg.Plugins,
+		events:     newEventHub(),
+	}
+	s.server.Handler = s
+
+	// Create the router and rebuild it whenever the providers change
+	r, err := s.newRouter()
+	if err != nil {
+		return nil, err
+	}
+	s.router.Store(r)
+	s.registry.Watch(s.rebuild)
+
 	// Set the handler for incoming socket messages and start the goroutine
 	s.herald.MessageHandler = s.messageHandler
 	s.herald.Start()
 
+	// Broadcast changes to lamps, providers, playback, jobs, and rules to
+	// clients
+	s.unsubscribe = s.registry.Subscribe(s.registryEventHandler)
+	s.sequencer.Watch(s.sequencerEventHandler)
+	s.scheduler.Watch(s.schedulerEventHandler)
+	s.automation.Watch(s.automationEventHandler)
+	s.presence.Watch(s.presenceEventHandler)
+
 	// Start the goroutine that listens for incoming connections
 	go func() {
 		defer s.logger.Info().Msg("server stopped")
@@ -114,15 +288,26 @@ func New(cfg *Config) (*Server, error) {
 }
 
 // Apply applies the provided changes to the specified provider.
-func (s *Server) Apply(provider_id string, changes []*registry.Change) error {
-	p, err := s.registry.GetProvider(provider_id)
-	if err != nil {
-		return err
-	}
-	return p.Apply(changes)
+func (s *Server) Apply(
+	ctx context.Context,
+	provider_id string,
+	changes []*registry.Change,
+) ([]*registry.Result, error) {
+	return s.registry.Apply(ctx, provider_id, changes)
+}
+
+// ApplyChanges applies changes to lamps in any number of providers.
+func (s *Server) ApplyChanges(
+	ctx context.Context,
+	changes []*registry.Change,
+) []*registry.Result {
+	return s.registry.ApplyChanges(ctx, changes)
 }
 
-// Close shuts down the server.
+// Close shuts down the server, cancelling any changes still being applied on
+// behalf of websocket clients.
 func (s *Server) Close() {
+	s.unsubscribe()
+	s.cancel()
 	s.server.Shutdown(context.Background())
 }
@@ -1,29 +1,147 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lampctl/lampctl/registry"
 	"github.com/nathan-osman/go-herald"
 )
 
+// wsClient stores the address of a websocket client, the protocol version it
+// negotiated, and the providers it has subscribed to. If providers is nil,
+// events for all providers are sent. Each connection has its own session for
+// undo.
+type wsClient struct {
+	mutex     sync.RWMutex
+	addr      string
+	session   string
+	version   int
+	providers map[string]bool
+}
+
+func (w *wsClient) getVersion() int {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.version
+}
+
+func (w *wsClient) setVersion(version int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.version = version
+}
+
+func (w *wsClient) setProviders(providerIDs []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(providerIDs) == 0 {
+		w.providers = nil
+		return
+	}
+	w.providers = make(map[string]bool)
+	for _, id := range providerIDs {
+		w.providers[id] = true
+	}
+}
+
+func (w *wsClient) subscribed(providerID string) bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.providers == nil || w.providers[providerID]
+}
+
 func (s *Server) api_ws_GET(c *gin.Context) {
-	s.herald.AddClient(c.Writer, c.Request, nil)
+	s.herald.AddClient(c.Writer, c.Request, &wsClient{
+		addr:    c.ClientIP(),
+		session: fmt.Sprintf("%s:%d", registry.SourceWebsocket, atomic.AddInt64(&s.wsSessions, 1)),
+	})
 }
 
+// wsContext returns the context used for changes made by the client, which
+// attributes them to its address and session.
+func (s *Server) wsContext(client *herald.Client) context.Context {
+	w := client.Data.(*wsClient)
+	return registry.WithSession(
+		s.ctx,
+		registry.SourceWebsocket,
+		w.addr,
+		w.session,
+	)
+}
+
+const (
+	messageTypeResults   = "results"
+	messageTypeSubscribe = "subscribe"
+)
+
+// wsMessage contains changes to apply. If ProviderID is empty, the changes
+// may be addressed to any provider using their own ProviderID.
 type wsMessage struct {
 	ProviderID string             `json:"provider_id"`
 	Changes    []*registry.Change `json:"changes"`
 }
 
+type wsResultsMessage struct {
+	ProviderID string             `json:"provider_id,omitempty"`
+	Results    []*registry.Result `json:"results"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// wsSubscribeMessage limits the events sent to the client to the specified
+// providers; an empty list restores the default of all providers.
+type wsSubscribeMessage struct {
+	ProviderIDs []string `json:"provider_ids"`
+}
+
 func (s *Server) messageHandler(m *herald.Message, client *herald.Client) {
+	if m.Type == messageTypeHello ||
+		client.Data.(*wsClient).getVersion() > 0 {
+		s.handleRequest(m, client)
+		return
+	}
+	if m.Type == messageTypeSubscribe {
+		s.subscribe(m, client)
+		return
+	}
 	v := &wsMessage{}
 	if err := json.Unmarshal(m.Data, v); err != nil {
 		s.logger.Error().Msg(err.Error())
 		return
 	}
-	if err := s.Apply(v.ProviderID, v.Changes); err != nil {
+	r := &wsResultsMessage{
+		ProviderID: v.ProviderID,
+	}
+	if v.ProviderID == "" {
+		r.Results = s.ApplyChanges(s.wsContext(client), v.Changes)
+	} else {
+		results, err := s.Apply(s.wsContext(client), v.ProviderID, v.Changes)
+		if err != nil {
+			s.logger.Error().Msg(err.Error())
+			r.Error = err.Error()
+		} else {
+			r.Results = results
+		}
+	}
+
+	// Let the client know which of the changes were applied
+	msg, err := herald.NewMessage(messageTypeResults, r)
+	if err != nil {
+		s.logger.Error().Msg(err.Error())
+		return
+	}
+	s.herald.Send(msg, []*herald.Client{client})
+}
+
+func (s *Server) subscribe(m *herald.Message, client *herald.Client) {
+	v := &wsSubscribeMessage{}
+	if err := json.Unmarshal(m.Data, v); err != nil {
 		s.logger.Error().Msg(err.Error())
+		return
 	}
+	client.Data.(*wsClient).setProviders(v.ProviderIDs)
 }
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nathan-osman/go-herald"
+)
+
+// protocolVersion is the newest version of the websocket protocol. Clients
+// that never send a hello message use version 0, where every message other
+// than subscribe is treated as a list of changes and nothing is returned
+// except the results.
+const protocolVersion = 1
+
+const (
+	messageTypeHello     = "hello"
+	messageTypeApply     = "apply"
+	messageTypeScene     = "scene"
+	messageTypeSequencer = "sequencer"
+	messageTypePing      = "ping"
+	messageTypeUndo      = "undo"
+	messageTypeRedo      = "redo"
+	messageTypeResponse  = "response"
+	messageTypeError     = "error"
+
+	sequencerActionLoad = "load"
+	sequencerActionPlay = "play"
+	sequencerActionStop = "stop"
+
+	undoScopeSession = "session"
+	undoScopeGlobal  = "global"
+)
+
+var (
+	errUnsupportedVersion = errors.New("no supported protocol version")
+	errInvalidAction      = errors.New("invalid sequencer action")
+	errInvalidMessageType = errors.New("invalid message type")
+)
+
+// wsRequest is the data of every message sent by a client once a version
+// has been negotiated. The ID is chosen by the client and included in the
+// response so that it can be matched with the request.
+type wsRequest struct {
+	ID     string          `json:"id"`
+	Params json.RawMessage `json:"params"`
+}
+
+// wsResponse is sent when a request succeeds.
+type wsResponse struct {
+	ID     string      `json:"id"`
+	Type   string      `json:"type"`
+	Result interface{} `json:"result"`
+}
+
+// wsError is sent when a request fails. Status uses the same codes as the
+// REST API would for the same error.
+type wsError struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+type wsHelloParams struct {
+	Versions []int `json:"versions"`
+}
+
+type wsHelloResult struct {
+	Version int `json:"version"`
+}
+
+type wsSceneParams struct {
+	ID int64 `json:"id"`
+}
+
+type wsSequencerParams struct {
+	Action string `json:"action"`
+	sequencerLoadJSON
+}
+
+type wsUndoParams struct {
+	Global bool `json:"global"`
+}
+
+type wsPingResult struct {
+	Time time.Time `json:"time"`
+}
+
+// wsHandler processes the parameters of a request and returns the result.
+type wsHandler func(client *herald.Client, params json.RawMessage) (interface{}, error)
+
+func (s *Server) wsHandlers() map[string]wsHandler {
+	return map[string]wsHandler{
+		messageTypeHello:     s.wsHello,
+		messageTypeApply:     s.wsApply,
+		messageTypeSubscribe: s.wsSubscribe,
+		messageTypeScene:     s.wsScene,
+		messageTypeSequencer: s.wsSequencer,
+		messageTypePing:      s.wsPing,
+		messageTypeUndo:      s.wsUndo,
+		messageTypeRedo:      s.wsRedo,
+	}
+}
+
+// decodeParams unmarshals the parameters if any were provided.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(params, v)
+}
+
+// handleRequest dispatches a request from a client that has negotiated a
+// protocol version and sends the response.
+func (s *Server) handleRequest(m *herald.Message, client *herald.Client) {
+	req := &wsRequest{}
+	if err := json.Unmarshal(m.Data, req); err != nil {
+		s.sendError(client, req.ID, m.Type, err)
+		return
+	}
+	fn, ok := s.wsHandlers()[m.Type]
+	if !ok {
+		s.sendError(client, req.ID, m.Type, fmt.Errorf("%w %q", errInvalidMessageType, m.Type))
+		return
+	}
+	result, err := fn(client, req.Params)
+	if err != nil {
+		s.sendError(client, req.ID, m.Type, err)
+		return
+	}
+	s.send(client, messageTypeResponse, &wsResponse{
+		ID:     req.ID,
+		Type:   m.Type,
+		Result: result,
+	})
+}
+
+func (s *Server) send(client *herald.Client, messageType string, v interface{}) {
+	msg, err := herald.NewMessage(messageType, v)
+	if err != nil {
+		s.logger.Error().Msg(err.Error())
+		return
+	}
+	s.herald.Send(msg, []*herald.Client{client})
+}
+
+func (s *Server) sendError(client *herald.Client, id, messageType string, err error) {
+	s.send(client, messageTypeError, &wsError{
+		ID:     id,
+		Type:   messageType,
+		Error:  err.Error(),
+		Status: errorStatus(err),
+	})
+}
+
+// wsHello selects the newest protocol version supported by both the client
+// and the server.
+func (s *Server) wsHello(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsHelloParams{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	version := 0
+	for _, n := range v.Versions {
+		if n <= protocolVersion && n > version {
+			version = n
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%w: server supports up to %d", errUnsupportedVersion, protocolVersion)
+	}
+	client.Data.(*wsClient).setVersion(version)
+	return &wsHelloResult{Version: version}, nil
+}
+
+func (s *Server) wsApply(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsMessage{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	if v.ProviderID == "" {
+		return s.ApplyChanges(s.wsContext(client), v.Changes), nil
+	}
+	return s.Apply(s.wsContext(client), v.ProviderID, v.Changes)
+}
+
+func (s *Server) wsSubscribe(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsSubscribeMessage{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	client.Data.(*wsClient).setProviders(v.ProviderIDs)
+	return v, nil
+}
+
+func (s *Server) wsScene(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsSceneParams{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	return s.registry.ApplyScene(s.wsContext(client), v.ID)
+}
+
+func (s *Server) wsSequencer(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsSequencerParams{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	switch v.Action {
+	case sequencerActionLoad:
+		if err := s.sequencer.Load(
+			v.AudioFilename,
+			v.MidiFilename,
+			v.MappingFilename,
+		); err != nil {
+			return nil, err
+		}
+	case sequencerActionPlay:
+		s.sequencer.Play()
+	case sequencerActionStop:
+		s.sequencer.Stop()
+	default:
+		return nil, fmt.Errorf("%w %q", errInvalidAction, v.Action)
+	}
+	return struct{}{}, nil
+}
+
+func (s *Server) wsUndo(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsUndoParams{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	return s.registry.Undo(s.wsContext(client), v.Global)
+}
+
+func (s *Server) wsRedo(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	v := &wsUndoParams{}
+	if err := decodeParams(params, v); err != nil {
+		return nil, err
+	}
+	return s.registry.Redo(s.wsContext(client), v.Global)
+}
+
+func (s *Server) wsPing(client *herald.Client, params json.RawMessage) (interface{}, error) {
+	return &wsPingResult{Time: time.Now()}, nil
+}
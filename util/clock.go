@@ -0,0 +1,41 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidTime     = errors.New("invalid time")
+	ErrInvalidTimezone = errors.New("invalid timezone")
+)
+
+// MaxSleep limits how long goroutines that wait until a certain time sleep
+// before checking again. Timers use the monotonic clock, so changes to the
+// system clock (such as when it is first synchronized) would otherwise go
+// unnoticed until the timer fired.
+const MaxSleep = time.Minute
+
+// ParseClock converts a time of day in the form "15:04" to minutes after
+// midnight.
+func ParseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q", ErrInvalidTime, v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LoadTimezone returns the location with the specified name or the local
+// timezone if the name is empty.
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrInvalidTimezone, name)
+	}
+	return loc, nil
+}
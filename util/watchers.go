@@ -0,0 +1,30 @@
+package util
+
+import (
+	"sync"
+)
+
+// Watchers is a list of functions that are invoked for each event sent by a
+// package, such as the scheduler. The zero value is ready to use. The functions are called synchronously from the
+// goroutine that sent the event and must not block.
+type Watchers[T any] struct {
+	mutex sync.Mutex
+	fns   []func(T)
+}
+
+// Add registers a function that is invoked for every event.
+func (w *Watchers[T]) Add(fn func(T)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.fns = append(w.fns, fn)
+}
+
+// Notify invokes each of the functions with the event.
+func (w *Watchers[T]) Notify(e T) {
+	w.mutex.Lock()
+	fns := append([]func(T){}, w.fns...)
+	w.mutex.Unlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+}
@@ -0,0 +1,186 @@
+package virtual
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lampctl/lampctl/db"
+	virtual_db "github.com/lampctl/lampctl/virtual/db"
+)
+
+type api_virtual_groups_POST_params struct {
+	Name     string `json:"name"`
+	NumLamps int    `json:"num_lamps"`
+	Dimmable bool   `json:"dimmable"`
+	Color    bool   `json:"color"`
+}
+
+func (v *Virtual) api_virtual_groups_POST(c *gin.Context) {
+	p := &api_virtual_groups_POST_params{}
+	if err := c.ShouldBindJSON(p); err != nil {
+		panic(err)
+	}
+	g := &Group{
+		Group: &virtual_db.Group{
+			Name: p.Name,
+		},
+		lamps: make(map[int64]*Lamp),
+	}
+	if err := v.db.Transaction(func(conn *db.Conn) error {
+		if err := conn.Save(g.Group).Error; err != nil {
+			return err
+		}
+		for i := 0; i < p.NumLamps; i++ {
+			l := &virtual_db.Lamp{
+				GroupID:  g.ID,
+				Name:     fmt.Sprintf("Lamp %03d", i+1),
+				Dimmable: p.Dimmable,
+				Color:    p.Color,
+			}
+			if err := conn.Save(l).Error; err != nil {
+				return err
+			}
+			g.lamps[l.ID] = &Lamp{Lamp: l}
+		}
+		return nil
+	}); err != nil {
+		panic(err)
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.groups[g.ID] = g
+	c.JSON(http.StatusOK, g.Group)
+}
+
+func (v *Virtual) api_virtual_groups_id_POST(c *gin.Context) {
+	r := &virtual_db.Group{}
+	if err := c.ShouldBindJSON(r); err != nil {
+		panic(err)
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	g, err := v.findGroup(c.Param("id"))
+	if err != nil {
+		panic(err)
+	}
+	if err := v.db.Model(g.Group).Update("name", r.Name).Error; err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, g.Group)
+}
+
+func (v *Virtual) api_virtual_groups_id_DELETE(c *gin.Context) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	g, err := v.findGroup(c.Param("id"))
+	if err != nil {
+		panic(err)
+	}
+	if err := v.db.Transaction(func(conn *db.Conn) error {
+		if err := conn.Where("group_id = ?", g.ID).Delete(&virtual_db.Lamp{}).Error; err != nil {
+			return err
+		}
+		return conn.Delete(g.Group).Error
+	}); err != nil {
+		panic(err)
+	}
+	delete(v.groups, g.ID)
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (v *Virtual) api_virtual_lamps_POST(c *gin.Context) {
+	l := &virtual_db.Lamp{}
+	if err := c.ShouldBindJSON(l); err != nil {
+		panic(err)
+	}
+	l.ID = 0
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	g, err := v.findGroup(fmt.Sprint(l.GroupID))
+	if err != nil {
+		panic(err)
+	}
+	if err := v.db.Save(l).Error; err != nil {
+		panic(err)
+	}
+	g.lamps[l.ID] = &Lamp{Lamp: l}
+	c.JSON(http.StatusOK, l)
+}
+
+func (v *Virtual) api_virtual_lamps_id_POST(c *gin.Context) {
+	r := &virtual_db.Lamp{}
+	if err := c.ShouldBindJSON(r); err != nil {
+		panic(err)
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	l, err := v.findLamp(fmt.Sprint(r.GroupID), c.Param("id"))
+	if err != nil {
+		panic(err)
+	}
+	if err := v.db.Model(l.Lamp).Updates(map[string]interface{}{
+		"name":     r.Name,
+		"dimmable": r.Dimmable,
+		"color":    r.Color,
+	}).Error; err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, l.Lamp)
+}
+
+type api_virtual_lamps_id_DELETE_params struct {
+	GroupID int64 `form:"group_id"`
+}
+
+func (v *Virtual) api_virtual_lamps_id_DELETE(c *gin.Context) {
+	p := &api_virtual_lamps_id_DELETE_params{}
+	if err := c.ShouldBindQuery(p); err != nil {
+		panic(err)
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	l, err := v.findLamp(fmt.Sprint(p.GroupID), c.Param("id"))
+	if err != nil {
+		panic(err)
+	}
+	if err := v.db.Delete(l.Lamp).Error; err != nil {
+		panic(err)
+	}
+	delete(v.groups[l.GroupID].lamps, l.ID)
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (v *Virtual) api_virtual_log_GET(c *gin.Context) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": v.logEnabled,
+		"entries": append([]*LogEntry{}, v.log...),
+	})
+}
+
+type api_virtual_log_POST_params struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (v *Virtual) api_virtual_log_POST(c *gin.Context) {
+	p := &api_virtual_log_POST_params{}
+	if err := c.ShouldBindJSON(p); err != nil {
+		panic(err)
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if err := v.db.SetBoolSetting(KeyLog, p.Enabled); err != nil {
+		panic(err)
+	}
+	v.logEnabled = p.Enabled
+	c.JSON(http.StatusOK, p)
+}
+
+func (v *Virtual) api_virtual_log_DELETE(c *gin.Context) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.log = nil
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,10 @@
+package virtual
+
+import (
+	"github.com/lampctl/lampctl/db"
+)
+
+// Config provides the configuration for the virtual provider.
+type Config struct {
+	DB *db.Conn
+}
@@ -0,0 +1,7 @@
+package db
+
+// Group provides database storage for a group of virtual lamps.
+type Group struct {
+	ID   int64  `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"not null" json:"name"`
+}
@@ -0,0 +1,10 @@
+package db
+
+// Lamp provides database storage for a virtual lamp and its capabilities.
+type Lamp struct {
+	ID       int64  `gorm:"primaryKey" json:"id"`
+	GroupID  int64  `gorm:"not null;index" json:"group_id"`
+	Name     string `gorm:"not null" json:"name"`
+	Dimmable bool   `gorm:"not null" json:"dimmable"`
+	Color    bool   `gorm:"not null" json:"color"`
+}
@@ -0,0 +1,253 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lampctl/lampctl/db"
+	"github.com/lampctl/lampctl/registry"
+	virtual_db "github.com/lampctl/lampctl/virtual/db"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	KeyLog = "virtual.log"
+
+	ProviderID   = "virtual"
+	ProviderName = "Virtual"
+
+	// maxLogEntries limits the number of changes kept in memory.
+	maxLogEntries = 1000
+)
+
+// LogEntry records a change applied to a virtual lamp.
+type LogEntry struct {
+	Time       time.Time       `json:"time"`
+	GroupID    string          `json:"group_id"`
+	LampID     string          `json:"lamp_id"`
+	State      bool            `json:"state"`
+	Brightness float64         `json:"brightness"`
+	Color      *registry.Color `json:"color"`
+}
+
+// Lamp represents a virtual lamp and its current state.
+type Lamp struct {
+	*virtual_db.Lamp
+	state      bool
+	brightness float64
+	color      *registry.Color
+}
+
+// apply updates the lamp's state, ignoring anything it is not capable of.
+func (l *Lamp) apply(c *registry.Change) {
+	l.state = c.State
+	if l.Dimmable {
+		l.brightness = c.Brightness
+	}
+	if l.Color && c.Color != nil {
+		l.color = c.Color
+	}
+}
+
+// Group represents a group of virtual lamps.
+type Group struct {
+	*virtual_db.Group
+	lamps map[int64]*Lamp
+}
+
+// Virtual implements the Provider interface for lamps that exist only in
+// memory, allowing lampctl to be used without any hardware.
+type Virtual struct {
+	mutex      sync.RWMutex
+	logger     zerolog.Logger
+	db         *db.Conn
+	groups     map[int64]*Group
+	logEnabled bool
+	log        []*LogEntry
+}
+
+func parseID(id string) (int64, error) {
+	return strconv.ParseInt(id, 10, 64)
+}
+
+func (v *Virtual) findGroup(id string) (*Group, error) {
+	i, err := parseID(id)
+	if err != nil {
+		return nil, registry.ErrInvalidGroup
+	}
+	g, ok := v.groups[i]
+	if !ok {
+		return nil, registry.ErrInvalidGroup
+	}
+	return g, nil
+}
+
+func (v *Virtual) findLamp(groupID, lampID string) (*Lamp, error) {
+	g, err := v.findGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	i, err := parseID(lampID)
+	if err != nil {
+		return nil, registry.ErrInvalidLamp
+	}
+	l, ok := g.lamps[i]
+	if !ok {
+		return nil, registry.ErrInvalidLamp
+	}
+	return l, nil
+}
+
+// record adds the change to the log if logging is enabled. The mutex must be
+// held.
+func (v *Virtual) record(l *Lamp) {
+	if !v.logEnabled {
+		return
+	}
+	e := &LogEntry{
+		Time:       time.Now(),
+		GroupID:    fmt.Sprint(l.GroupID),
+		LampID:     fmt.Sprint(l.ID),
+		State:      l.state,
+		Brightness: l.brightness,
+		Color:      l.color,
+	}
+	ev := v.logger.Info().
+		Time("time", e.Time).
+		Str("group_id", e.GroupID).
+		Str("lamp_id", e.LampID).
+		Bool("state", e.State).
+		Float64("brightness", e.Brightness)
+	if e.Color != nil {
+		ev = ev.Str("color", e.Color.String())
+	}
+	ev.Msg("change applied")
+	v.log = append(v.log, e)
+	if len(v.log) > maxLogEntries {
+		v.log = v.log[len(v.log)-maxLogEntries:]
+	}
+}
+
+// New creates a new Virtual instance.
+func New(cfg *Config) (*Virtual, error) {
+	logEnabled, err := cfg.DB.GetBoolSetting(KeyLog, false)
+	if err != nil {
+		return nil, err
+	}
+	v := &Virtual{
+		logger:     log.With().Str("package", "virtual").Logger(),
+		db:         cfg.DB,
+		groups:     make(map[int64]*Group),
+		logEnabled: logEnabled,
+	}
+	if err := v.db.AutoMigrate(
+		&virtual_db.Group{},
+		&virtual_db.Lamp{},
+	); err != nil {
+		return nil, err
+	}
+	groups := []*virtual_db.Group{}
+	if err := v.db.Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		v.groups[g.ID] = &Group{
+			Group: g,
+			lamps: make(map[int64]*Lamp),
+		}
+	}
+	lamps := []*virtual_db.Lamp{}
+	if err := v.db.Find(&lamps).Error; err != nil {
+		return nil, err
+	}
+	for _, l := range lamps {
+		if g, ok := v.groups[l.GroupID]; ok {
+			g.lamps[l.ID] = &Lamp{Lamp: l}
+		}
+	}
+	return v, nil
+}
+
+func (v *Virtual) ID() string {
+	return ProviderID
+}
+
+func (v *Virtual) Name() string {
+	return ProviderName
+}
+
+func (v *Virtual) Init(api *gin.RouterGroup) error {
+	api.POST("/virtual/groups", v.api_virtual_groups_POST)
+	api.POST("/virtual/groups/:id", v.api_virtual_groups_id_POST)
+	api.DELETE("/virtual/groups/:id", v.api_virtual_groups_id_DELETE)
+	api.POST("/virtual/lamps", v.api_virtual_lamps_POST)
+	api.POST("/virtual/lamps/:id", v.api_virtual_lamps_id_POST)
+	api.DELETE("/virtual/lamps/:id", v.api_virtual_lamps_id_DELETE)
+	api.GET("/virtual/log", v.api_virtual_log_GET)
+	api.POST("/virtual/log", v.api_virtual_log_POST)
+	api.DELETE("/virtual/log", v.api_virtual_log_DELETE)
+	return nil
+}
+
+func (v *Virtual) Close() {}
+
+func (v *Virtual) Groups() []*registry.Group {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	groups := []*registry.Group{}
+	for _, g := range v.groups {
+		groups = append(groups, &registry.Group{
+			ID:   fmt.Sprint(g.ID),
+			Name: g.Name,
+		})
+	}
+	return groups
+}
+
+func (v *Virtual) Lamps() []*registry.Lamp {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	lamps := []*registry.Lamp{}
+	for _, g := range v.groups {
+		for _, l := range g.lamps {
+			lamps = append(lamps, &registry.Lamp{
+				ID:      fmt.Sprint(l.ID),
+				Name:    l.Name,
+				GroupID: fmt.Sprint(g.ID),
+				State:   l.state,
+			})
+		}
+	}
+	return lamps
+}
+
+func (v *Virtual) Apply(ctx context.Context, changes []*registry.Change) []*registry.Result {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return registry.ApplyEach(changes, func(c *registry.Change) error {
+		l, err := v.findLamp(c.GroupID, c.LampID)
+		if err != nil {
+			return err
+		}
+		l.apply(c)
+		v.record(l)
+		return nil
+	})
+}
+
+func (v *Virtual) ApplyToAll(ctx context.Context, change *registry.Change) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for _, g := range v.groups {
+		for _, l := range g.lamps {
+			l.apply(change)
+			v.record(l)
+		}
+	}
+	return nil
+}
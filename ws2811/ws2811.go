@@ -1,6 +1,7 @@
 package ws2811
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -15,12 +16,33 @@ import (
 const (
 	KeyNumLeds = "ws2811.numLeds"
 
-	ProviderID = "ws2811"
-	GroupID    = "ws2811"
+	ProviderID   = "ws2811"
+	ProviderName = "ws2811"
+	GroupID      = "ws2811"
 )
 
 var errNoLEDs = errors.New("LED count is set to 0")
 
+// ledColor converts the color in a change to the 0xRRGGBB value expected by
+// the LED string, defaulting to white if the lamp is on and no color is set.
+// The color is scaled according to the brightness.
+func ledColor(c *registry.Change) uint32 {
+	if !c.State {
+		return 0
+	}
+	color := registry.NewRGBColor(0xff, 0xff, 0xff)
+	if c.Color != nil {
+		color = &registry.Color{Color: c.Color.Color}
+	}
+	if c.Brightness > 0 && c.Brightness < 1 {
+		color.R *= c.Brightness
+		color.G *= c.Brightness
+		color.B *= c.Brightness
+	}
+	r, g, b := color.RGB()
+	return uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+}
+
 // Ws2811 implements the Provider interface for ws2811.
 type Ws2811 struct {
 	mutex   sync.Mutex
@@ -84,7 +106,7 @@ func (w *Ws2811) ID() string {
 }
 
 func (w *Ws2811) Name() string {
-	return "ws2811"
+	return ProviderName
 }
 
 func (w *Ws2811) Init(api *gin.RouterGroup) error {
@@ -128,42 +150,44 @@ func (w *Ws2811) Lamps() []*registry.Lamp {
 	return lamps
 }
 
-func (w *Ws2811) Apply(changes []*registry.Change) error {
+func (w *Ws2811) Apply(ctx context.Context, changes []*registry.Change) []*registry.Result {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 	if w.ws == nil {
-		return errNoLEDs
+		return registry.NewResults(changes, errNoLEDs)
 	}
-	for _, c := range changes {
+	results := registry.ApplyEach(changes, func(c *registry.Change) error {
 		if c.GroupID != GroupID {
-			return fmt.Errorf("invalid group ID %s", c.GroupID)
+			return registry.ErrInvalidGroup
 		}
 		i, err := strconv.Atoi(c.LampID)
-		if err != nil {
-			return err
-		}
-		if i < 0 || i >= w.numLEDs {
-			return fmt.Errorf("invalid lamp ID %d", i)
+		if err != nil || i < 0 || i >= w.numLEDs {
+			return registry.ErrInvalidLamp
 		}
-		color, _ := strconv.ParseInt(c.Color, 16, 32)
-		if color == 0 && c.State {
-			color = 0xffffff
+		w.ws.Leds(0)[i] = ledColor(c)
+		return nil
+	})
+
+	// The LEDs are updated all at once, so a failure to render means that
+	// none of the valid changes took effect
+	if err := w.ws.Render(); err != nil {
+		for _, r := range results {
+			if r.Status == registry.ResultApplied {
+				r.Status = registry.ResultFailed
+				r.Error = err.Error()
+			}
 		}
-		w.ws.Leds(0)[i] = uint32(color)
 	}
-	return w.ws.Render()
+	return results
 }
 
-func (w *Ws2811) ApplyToAll(change *registry.Change) error {
+func (w *Ws2811) ApplyToAll(ctx context.Context, change *registry.Change) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 	if w.ws == nil {
 		return errNoLEDs
 	}
-	var color uint32
-	if change.State {
-		color = 0xffffff
-	}
+	color := ledColor(change)
 	for i := 0; i < len(w.ws.Leds(0)); i++ {
 		w.ws.Leds(0)[i] = color
 	}